@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backendSampler samples the resource usage of the PostgreSQL backend process
+// executing the current query, see -backend-stats. The stats are read from
+// /proc, so it only works if PostgreSQL runs on the same Linux host.
+type backendSampler struct {
+	mu       sync.Mutex
+	query    *Query
+	pid      int
+	cpuStart time.Duration
+	stats    map[*Query]*backendStats
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// backendStats are the resource usage stats of a single query.
+type backendStats struct {
+	CPU     []float64
+	RSSPeak float64
+	RSSSum  float64
+	RSSN    int
+}
+
+// newBackendSampler returns a backendSampler that samples the memory usage
+// of the backend every interval. Stop must be called to release it.
+func newBackendSampler(interval time.Duration) *backendSampler {
+	s := &backendSampler{
+		stats: map[*Query]*backendStats{},
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.loop(interval)
+	return s
+}
+
+func (s *backendSampler) loop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		if s.query != nil {
+			// The backend may have exited, e.g. after a timeout, in which case
+			// we just miss a sample.
+			if rss, err := readBackendRSS(s.pid); err == nil {
+				stats := s.statsFor(s.query)
+				stats.RSSSum += rss
+				stats.RSSN++
+				if rss > stats.RSSPeak {
+					stats.RSSPeak = rss
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Begin must be called before executing q on the backend with the given pid.
+func (s *backendSampler) Begin(q *Query, pid int) error {
+	cpu, err := readBackendCPU(pid)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.query, s.pid, s.cpuStart = q, pid, cpu
+	return nil
+}
+
+// End must be called after executing the query passed to Begin. The CPU time
+// of the execution is recorded if ok is true.
+func (s *backendSampler) End(ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.query == nil {
+		return
+	}
+	if cpu, err := readBackendCPU(s.pid); ok && err == nil {
+		stats := s.statsFor(s.query)
+		stats.CPU = append(stats.CPU, (cpu - s.cpuStart).Seconds())
+	}
+	s.query = nil
+}
+
+// Apply copies the stats sampled so far into queries.
+func (s *backendSampler) Apply(queries []*Query) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range queries {
+		stats, ok := s.stats[q]
+		if !ok {
+			continue
+		}
+		q.BackendCPU = append(q.BackendCPU[:0], stats.CPU...)
+		q.BackendPeakRSS = stats.RSSPeak
+		if stats.RSSN > 0 {
+			q.BackendMeanRSS = stats.RSSSum / float64(stats.RSSN)
+		}
+	}
+}
+
+// Stop stops sampling.
+func (s *backendSampler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *backendSampler) statsFor(q *Query) *backendStats {
+	stats, ok := s.stats[q]
+	if !ok {
+		stats = &backendStats{}
+		s.stats[q] = stats
+	}
+	return stats
+}
+
+// readBackendRSS returns the resident set size of the process with the given
+// pid in bytes.
+func readBackendRSS(pid int) (float64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	return parseVmRSS(string(data))
+}
+
+// parseVmRSS returns the VmRSS of the given /proc/<pid>/status contents in
+// bytes.
+func parseVmRSS(status string) (float64, error) {
+	for _, line := range strings.Split(status, "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "VmRSS:"))
+		if len(fields) != 2 || fields[1] != "kB" {
+			return 0, fmt.Errorf("bad VmRSS line: %q", line)
+		}
+		kb, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad VmRSS line: %q: %w", line, err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, errors.New("missing VmRSS")
+}
+
+// readBackendCPU returns the CPU time used by the process with the given pid
+// so far. It's read from /proc/<pid>/schedstat, which has nanosecond
+// resolution unlike /proc/<pid>/stat.
+func readBackendCPU(pid int) (time.Duration, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/schedstat", pid))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("bad schedstat: %q", data)
+	}
+	ns, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad schedstat: %q: %w", data, err)
+	}
+	return time.Duration(ns), nil
+}
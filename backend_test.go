@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_parseVmRSS(t *testing.T) {
+	status := "Name:\tpostgres\nVmPeak:\t  220000 kB\nVmRSS:\t   12345 kB\nThreads:\t1\n"
+	if got, err := parseVmRSS(status); err != nil {
+		t.Fatal(err)
+	} else if want := 12345.0 * 1024; got != want {
+		t.Fatalf("got=%f want=%f", got, want)
+	}
+	if _, err := parseVmRSS("Name:\tpostgres\n"); err == nil {
+		t.Fatal("expected error for missing VmRSS")
+	}
+}
+
+func Test_backendSampler(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires /proc")
+	}
+	// Sample our own process in lieu of a PostgreSQL backend.
+	s := newBackendSampler(time.Millisecond)
+	defer s.Stop()
+	q := &Query{Name: "self"}
+	if err := s.Begin(q, os.Getpid()); err != nil {
+		t.Skipf("can't read process stats: %s", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	s.End(true)
+	s.Apply([]*Query{q})
+	if len(q.BackendCPU) != 1 || q.BackendCPU[0] < 0 {
+		t.Fatalf("unexpected cpu: %v", q.BackendCPU)
+	} else if q.BackendPeakRSS <= 0 || q.BackendMeanRSS <= 0 {
+		t.Fatalf("unexpected rss: peak=%f mean=%f", q.BackendPeakRSS, q.BackendMeanRSS)
+	}
+}
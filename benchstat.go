@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const (
+	// formatTable displays the stats as a table, see render.
+	formatTable = "table"
+	// formatBenchstat prints the samples in the Go benchmark format, see
+	// writeBenchstat.
+	formatBenchstat = "benchstat"
+)
+
+// outputFormats are the values accepted by -f.
+var outputFormats = []string{formatTable, formatBenchstat}
+
+// writeBenchstat writes every sample of queries to w as a line of the Go
+// benchmark format, e.g. "BenchmarkQueryName 1 1234567 ns/op", so the results
+// can be compared via golang.org/x/perf/cmd/benchstat.
+func writeBenchstat(w io.Writer, queries []*Query) error {
+	for _, q := range queries {
+		name := benchstatName(q.Name)
+		for _, seconds := range q.Seconds {
+			if _, err := fmt.Fprintf(w, "%s 1 %.0f ns/op\n", name, seconds*1e9); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// benchstatName returns the benchmark name for the query with the given name.
+// Whitespace is replaced because it separates the fields of a benchmark line.
+func benchstatName(name string) string {
+	return "Benchmark" + strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
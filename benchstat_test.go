@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_writeBenchstat(t *testing.T) {
+	queries := []*Query{
+		{Name: "fast", Seconds: []float64{0.001, 0.0015}},
+		{Name: "slow (prepared)", Seconds: []float64{1.25}},
+	}
+	var buf bytes.Buffer
+	if err := writeBenchstat(&buf, queries); err != nil {
+		t.Fatal(err)
+	}
+	want := "Benchmarkfast 1 1000000 ns/op\nBenchmarkfast 1 1500000 ns/op\nBenchmarkslow_(prepared) 1 1250000000 ns/op\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
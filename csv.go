@@ -1,31 +1,63 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/csv"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// csvFetchTimeout is the timeout for downloading CSV files given as URL.
+const csvFetchTimeout = time.Minute
+
+// openCSVInput opens the CSV file at csvPath, which may also be a http:// or
+// https:// URL to download it from.
+func openCSVInput(csvPath string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(csvPath, "http://") && !strings.HasPrefix(csvPath, "https://") {
+		return os.Open(csvPath)
+	}
+	client := &http.Client{Timeout: csvFetchTimeout}
+	res, err := client.Get(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", csvPath, err)
+	} else if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("fetching %s: unexpected status: %s", csvPath, res.Status)
+	}
+	return res.Body, nil
+}
+
 type CSVRow struct {
 	Iteration int64
 	Query     string
 	Seconds   float64
+	SQLHash   string
+	// Rows is the number of rows returned by the query, or -1 if unknown.
+	Rows float64
+	// Plan is the fingerprint of the query plan, if known.
+	Plan string
 }
 
-func (r *CSVRow) UnmarshalRecord(record []string) error {
+// UnmarshalRecord populates r from the given record, which must contain
+// the values of columns in the same order.
+func (r *CSVRow) UnmarshalRecord(record []string, columns []csvColumn) error {
 	for i, val := range record {
-		if err := csvColumns[i].UnmarshalColumn(val, r); err != nil {
+		if err := columns[i].UnmarshalColumn(val, r); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *CSVRow) MarshalRecord() ([]string, error) {
-	record := make([]string, len(csvColumns))
-	for i, col := range csvColumns {
+// MarshalRecord returns the values of columns for r.
+func (r *CSVRow) MarshalRecord(columns []csvColumn) ([]string, error) {
+	record := make([]string, len(columns))
+	for i, col := range columns {
 		val, err := col.MarshalColumn(r)
 		if err != nil {
 			return nil, err
@@ -36,7 +68,12 @@ func (r *CSVRow) MarshalRecord() ([]string, error) {
 }
 
 type csvColumn struct {
-	Name            string
+	Name string
+	// Required columns must be present in CSV files loaded via -i. Optional
+	// columns were added later, so older files don't have them.
+	Required bool
+	// Extra columns are only written to -o when selected via -csv-columns.
+	Extra           bool
 	UnmarshalColumn func(string, *CSVRow) error
 	MarshalColumn   func(*CSVRow) (string, error)
 }
@@ -44,6 +81,8 @@ type csvColumn struct {
 var csvColumns = []csvColumn{
 	{
 		"iteration",
+		true,
+		false,
 		func(val string, r *CSVRow) (err error) {
 			r.Iteration, err = strconv.ParseInt(val, 10, 64)
 			return
@@ -54,6 +93,8 @@ var csvColumns = []csvColumn{
 	},
 	{
 		"query",
+		true,
+		false,
 		func(val string, r *CSVRow) error {
 			r.Query = val
 			return nil
@@ -64,6 +105,8 @@ var csvColumns = []csvColumn{
 	},
 	{
 		"seconds",
+		true,
+		false,
 		func(val string, r *CSVRow) (err error) {
 			r.Seconds, err = strconv.ParseFloat(val, 64)
 			return
@@ -72,56 +115,207 @@ var csvColumns = []csvColumn{
 			return fmt.Sprintf("%f", r.Seconds), nil
 		},
 	},
+	{
+		"sql_hash",
+		false,
+		true,
+		func(val string, r *CSVRow) error {
+			r.SQLHash = val
+			return nil
+		},
+		func(r *CSVRow) (string, error) {
+			return r.SQLHash, nil
+		},
+	},
+	{
+		"rows",
+		false,
+		true,
+		func(val string, r *CSVRow) (err error) {
+			if val == "" {
+				r.Rows = -1
+				return nil
+			}
+			r.Rows, err = strconv.ParseFloat(val, 64)
+			return
+		},
+		func(r *CSVRow) (string, error) {
+			if r.Rows < 0 {
+				return "", nil
+			}
+			return fmt.Sprintf("%g", r.Rows), nil
+		},
+	},
+	{
+		"plan",
+		false,
+		true,
+		func(val string, r *CSVRow) error {
+			r.Plan = val
+			return nil
+		},
+		func(r *CSVRow) (string, error) {
+			return r.Plan, nil
+		},
+	},
 }
 
-// csvHeader returns the CSV header columns.
-func csvHeader() []string {
-	header := make([]string, len(csvColumns))
-	for i, col := range csvColumns {
+// parseCSVColumns returns the columns written to -o for the given comma
+// separated list of extra columns. Columns that aren't extra are always
+// included.
+func parseCSVColumns(extra string) ([]csvColumn, error) {
+	selected := map[string]bool{}
+	for _, name := range splitList(extra) {
+		col, ok := findCSVColumn(name)
+		if !ok || !col.Extra {
+			return nil, fmt.Errorf("unknown column: %q: must be one of %s", name, csvExtraColumnNames())
+		}
+		selected[name] = true
+	}
+	var columns []csvColumn
+	for _, col := range csvColumns {
+		if !col.Extra || selected[col.Name] {
+			columns = append(columns, col)
+		}
+	}
+	return columns, nil
+}
+
+// csvExtraColumnNames returns a comma separated list of the extra columns.
+func csvExtraColumnNames() string {
+	var names []string
+	for _, col := range csvColumns {
+		if col.Extra {
+			names = append(names, col.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// csvHeader returns the CSV header for the given columns.
+func csvHeader(columns []csvColumn) []string {
+	header := make([]string, len(columns))
+	for i, col := range columns {
 		header[i] = col.Name
 	}
 	return header
 }
 
-func loadCSVRows(csvPath string) ([]*CSVRow, error) {
-	data, err := ioutil.ReadFile(csvPath)
-	if err != nil {
-		return nil, err
+// csvMetadataKeys are the keys of the metadata written in front of the CSV
+// header, in order.
+var csvMetadataKeys = []string{"sqlbench version", "postgres version", "method", "planning", "started at", "args", "labels"}
+
+// writeCSVMetadata writes the values of metadata for the csvMetadataKeys as
+// "# key: value" comment lines to w.
+func writeCSVMetadata(w io.Writer, metadata map[string]string) error {
+	for _, key := range csvMetadataKeys {
+		if value, ok := metadata[key]; ok {
+			value = strings.ReplaceAll(value, "\n", " ")
+			if _, err := fmt.Fprintf(w, "# %s: %s\n", key, value); err != nil {
+				return err
+			}
+		}
 	}
-	cr := csv.NewReader(bytes.NewBuffer(data))
-	records, err := cr.ReadAll()
+	return nil
+}
+
+// readCSVRows reads the CSV file at csvPath and calls fn for every row. The
+// file is streamed, so it can be larger than the available memory. The
+// metadata written by writeCSVMetadata is returned, if any.
+func readCSVRows(csvPath string, fn func(*CSVRow) error) (map[string]string, error) {
+	file, err := openCSVInput(csvPath)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	var rows []*CSVRow
-	for i, record := range records {
-		if err := checkCSVColumns(record); err != nil {
-			return nil, fmt.Errorf("row=%d: %w", i+1, err)
+	br := bufio.NewReader(file)
+	metadata := map[string]string{}
+	for {
+		if b, err := br.Peek(1); err != nil || b[0] != '#' {
+			break
+		}
+		line, err := br.ReadString('\n')
+		if parts := strings.SplitN(strings.TrimPrefix(line, "#"), ":", 2); len(parts) == 2 {
+			metadata[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		if err != nil {
+			break
+		}
+	}
+	return metadata, readCSVRecords(br, fn)
+}
+
+// readCSVRecords reads the CSV header and rows from r and calls fn for every
+// row.
+func readCSVRecords(r io.Reader, fn func(*CSVRow) error) error {
+	cr := csv.NewReader(r)
+	// We validate the number of columns ourselves for better error messages.
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	var columns []csvColumn
+	for i := 0; ; i++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
 		}
 
 		switch i {
 		case 0:
-			for i, got := range record {
-				if want := csvColumns[i].Name; got != want {
-					return nil, fmt.Errorf("unexpected header column %d: got=%q want=%q", i, got, want)
-				}
+			if columns, err = parseCSVHeader(record); err != nil {
+				return err
 			}
 		default:
-			row := &CSVRow{}
-			if err := row.UnmarshalRecord(record); err != nil {
-				return nil, fmt.Errorf("row=%d: %w", i+1, err)
+			if err := checkCSVColumns(record, columns); err != nil {
+				return fmt.Errorf("row=%d: %w", i+1, err)
+			}
+			row := &CSVRow{Rows: -1}
+			if err := row.UnmarshalRecord(record, columns); err != nil {
+				return fmt.Errorf("row=%d: %w", i+1, err)
+			} else if err := fn(row); err != nil {
+				return err
 			}
-			rows = append(rows, row)
 		}
 	}
-	return rows, nil
+}
+
+// parseCSVHeader returns the csvColumns corresponding to the given header
+// record. It returns an error for unknown or missing required columns.
+func parseCSVHeader(header []string) ([]csvColumn, error) {
+	var columns []csvColumn
+	seen := map[string]bool{}
+	for i, name := range header {
+		col, ok := findCSVColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unexpected header column %d: %q", i, name)
+		}
+		columns = append(columns, col)
+		seen[name] = true
+	}
+	for _, col := range csvColumns {
+		if col.Required && !seen[col.Name] {
+			return nil, fmt.Errorf("missing header column: %q", col.Name)
+		}
+	}
+	return columns, nil
+}
+
+// findCSVColumn returns the csvColumn with the given name.
+func findCSVColumn(name string) (csvColumn, bool) {
+	for _, col := range csvColumns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return csvColumn{}, false
 }
 
 // checkCSVColumns returns an error if record doesn't have the right number
 // of columns.
-func checkCSVColumns(record []string) error {
-	if got, want := len(record), len(csvColumns); got != want {
+func checkCSVColumns(record []string, columns []csvColumn) error {
+	if got, want := len(record), len(columns); got != want {
 		return fmt.Errorf("bad number of columns: got=%d want=%d", got, want)
 	}
 	return nil
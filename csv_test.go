@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_parseCSVHeader(t *testing.T) {
+	columns, err := parseCSVHeader([]string{"iteration", "query", "seconds"})
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := len(columns), 3; got != want {
+		t.Fatalf("got=%d want=%d", got, want)
+	}
+
+	columns, err = parseCSVHeader([]string{"query", "sql_hash", "seconds", "iteration"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := &CSVRow{}
+	if err := row.UnmarshalRecord([]string{"gauss", "abcd1234", "0.5", "7"}, columns); err != nil {
+		t.Fatal(err)
+	} else if want := (CSVRow{Iteration: 7, Query: "gauss", Seconds: 0.5, SQLHash: "abcd1234"}); *row != want {
+		t.Fatalf("got=%+v want=%+v", *row, want)
+	}
+
+	if _, err := parseCSVHeader([]string{"iteration", "query"}); err == nil {
+		t.Fatal("expected error for missing column")
+	} else if _, err := parseCSVHeader([]string{"iteration", "query", "seconds", "foo"}); err == nil {
+		t.Fatal("expected error for unknown column")
+	}
+}
+
+func Test_parseCSVColumns(t *testing.T) {
+	columns, err := parseCSVColumns("")
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.Join(csvHeader(columns), ","), "iteration,query,seconds"; got != want {
+		t.Fatalf("got=%s want=%s", got, want)
+	}
+
+	columns, err = parseCSVColumns("plan,rows,sql_hash")
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.Join(csvHeader(columns), ","), "iteration,query,seconds,sql_hash,rows,plan"; got != want {
+		t.Fatalf("got=%s want=%s", got, want)
+	}
+	record, err := (&CSVRow{Iteration: 1, Query: "q", Seconds: 0.5, Rows: -1}).MarshalRecord(columns)
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.Join(record, ","), "1,q,0.500000,,,"; got != want {
+		t.Fatalf("got=%s want=%s", got, want)
+	}
+
+	if _, err := parseCSVColumns("seconds"); err == nil {
+		t.Fatal("expected error for non-extra column")
+	} else if _, err := parseCSVColumns("foo"); err == nil {
+		t.Fatal("expected error for unknown column")
+	}
+}
+
+func Test_readCSVRows_url(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/baseline.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "iteration,query,seconds\n1,a,0.5\n")
+	}))
+	defer server.Close()
+
+	var rows []CSVRow
+	_, err := readCSVRows(server.URL+"/baseline.csv", func(row *CSVRow) error {
+		rows = append(rows, *row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rows) != 1 || rows[0].Query != "a" || rows[0].Seconds != 0.5 {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+
+	_, err = readCSVRows(server.URL+"/missing.csv", func(*CSVRow) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Fatalf("got=%v want 404 error", err)
+	}
+}
+
+func Test_readCSVRows_metadata(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeCSVMetadata(&buf, map[string]string{
+		"method":           "client",
+		"planning":         "false",
+		"postgres version": "PostgreSQL 13.1\non x86_64",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# postgres version: PostgreSQL 13.1 on x86_64\n# method: client\n# planning: false\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+
+	buf.WriteString("iteration,query,seconds\n1,a,0.5\n")
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var rows int
+	metadata, err := readCSVRows(path, func(*CSVRow) error {
+		rows++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if rows != 1 {
+		t.Fatalf("got=%d rows want=1", rows)
+	} else if metadata["method"] != "client" || metadata["postgres version"] != "PostgreSQL 13.1 on x86_64" {
+		t.Fatalf("unexpected metadata: %v", metadata)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+// explainPlan is a plan node as returned by EXPLAIN (FORMAT JSON). Empty
+// fields are omitted when marshaling, see -plans-out.
+type explainPlan struct {
+	NodeType     string  `json:"Node Type,omitempty"`
+	Strategy     string  `json:"Strategy,omitempty"`
+	JoinType     string  `json:"Join Type,omitempty"`
+	RelationName string  `json:"Relation Name,omitempty"`
+	IndexName    string  `json:"Index Name,omitempty"`
+	ActualRows   float64 `json:"Actual Rows,omitempty"`
+	ActualLoops  float64 `json:"Actual Loops,omitempty"`
+	// PlanRows and TotalCost are the planner's estimates of the rows and the
+	// cost of the node.
+	PlanRows  float64 `json:"Plan Rows,omitempty"`
+	TotalCost float64 `json:"Total Cost,omitempty"`
+	// RowsRemovedByFilter is the number of rows per loop that were read but
+	// discarded by the filter of the node.
+	RowsRemovedByFilter float64 `json:"Rows Removed by Filter,omitempty"`
+	// Filter and IndexCond are the conditions applied by the node.
+	Filter    string `json:"Filter,omitempty"`
+	IndexCond string `json:"Index Cond,omitempty"`
+	// Output is the list of output columns of the node. Only available for
+	// EXPLAIN (VERBOSE), see -explain-verbose.
+	Output []string `json:"Output,omitempty"`
+	// SharedReadBlocks is the number of shared blocks read from disk or the
+	// OS cache by the node and its children. Only available for EXPLAIN
+	// (BUFFERS), otherwise nil.
+	SharedReadBlocks *float64 `json:"Shared Read Blocks,omitempty"`
+	// TempReadBlocks and TempWrittenBlocks are the number of temporary blocks
+	// used by the node and its children, e.g. for sorts or hashes exceeding
+	// work_mem. Only available for EXPLAIN (BUFFERS), otherwise nil.
+	TempReadBlocks    *float64 `json:"Temp Read Blocks,omitempty"`
+	TempWrittenBlocks *float64 `json:"Temp Written Blocks,omitempty"`
+	// SortSpaceType is "Disk" for sorts that spilled to disk.
+	SortSpaceType string `json:"Sort Space Type,omitempty"`
+	// HashBatches is greater than 1 for hashes that spilled to disk.
+	HashBatches float64        `json:"Hash Batches,omitempty"`
+	Plans       []*explainPlan `json:"Plans,omitempty"`
+}
+
+// Walk calls fn for p and all of its descendant nodes in depth-first order.
+func (p *explainPlan) Walk(fn func(node *explainPlan)) {
+	fn(p)
+	for _, child := range p.Plans {
+		child.Walk(fn)
+	}
+}
+
+// SeqScans returns all Seq Scan nodes of the plan that read at least minRows
+// rows in total.
+func (p *explainPlan) SeqScans(minRows float64) []*explainPlan {
+	var scans []*explainPlan
+	p.Walk(func(node *explainPlan) {
+		if node.NodeType == "Seq Scan" && node.ScannedRows() >= minRows {
+			scans = append(scans, node)
+		}
+	})
+	return scans
+}
+
+// NewSeqScans is like SeqScans, but omits the Seq Scan nodes on relations that
+// were already read by a Seq Scan in base, regardless of their rows.
+func (p *explainPlan) NewSeqScans(base *explainPlan, minRows float64) []*explainPlan {
+	var known []string
+	for _, scan := range base.SeqScans(0) {
+		known = append(known, scan.RelationName)
+	}
+	var scans []*explainPlan
+	for _, scan := range p.SeqScans(minRows) {
+		if !contains(known, scan.RelationName) {
+			scans = append(scans, scan)
+		}
+	}
+	return scans
+}
+
+// ScannedRows returns the number of rows read by the node across all loops,
+// including the rows removed by its filter.
+func (p *explainPlan) ScannedRows() float64 {
+	loops := p.ActualLoops
+	if loops < 1 {
+		loops = 1
+	}
+	return (p.ActualRows + p.RowsRemovedByFilter) * loops
+}
+
+// TempBlocks returns the number of temporary blocks read and written by the
+// node and its children, and false if unavailable.
+func (p *explainPlan) TempBlocks() (float64, bool) {
+	if p.TempReadBlocks == nil || p.TempWrittenBlocks == nil {
+		return 0, false
+	}
+	return *p.TempReadBlocks + *p.TempWrittenBlocks, true
+}
+
+// Spills returns true if any node of the plan spilled to disk because it
+// exceeded work_mem.
+func (p *explainPlan) Spills() bool {
+	var spills bool
+	p.Walk(func(node *explainPlan) {
+		if node.SortSpaceType == "Disk" || node.HashBatches > 1 {
+			spills = true
+		}
+	})
+	if blocks, ok := p.TempBlocks(); ok && blocks > 0 {
+		spills = true
+	}
+	return spills
+}
+
+// RowEstimateError returns the factor by which the planner misestimated the
+// number of rows of the node, e.g. 10 if it expected 10 times more or fewer
+// rows than were actually returned. It's 1 for perfect estimates. Both row
+// counts are clamped to at least 1, like the planner's estimates are.
+func (p *explainPlan) RowEstimateError() float64 {
+	estimated, actual := math.Max(p.PlanRows, 1), math.Max(p.ActualRows, 1)
+	return math.Max(estimated, actual) / math.Min(estimated, actual)
+}
+
+// Fingerprint returns a short hash of the structure of the plan. Runtime
+// information such as the number of rows is ignored, so two executions using
+// the same plan have the same fingerprint.
+func (p *explainPlan) Fingerprint() string {
+	var sb strings.Builder
+	p.writeStructure(&sb)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(sb.String())))[:8]
+}
+
+func (p *explainPlan) writeStructure(sb *strings.Builder) {
+	fmt.Fprintf(sb, "%s|%s|%s|%s|%s(", p.NodeType, p.Strategy, p.JoinType, p.RelationName, p.IndexName)
+	for _, child := range p.Plans {
+		child.writeStructure(sb)
+	}
+	sb.WriteString(")")
+}
+
+// Label returns a short description of the node, e.g. "Index Scan on t using
+// t_pkey" or "Hash Join (Left)".
+func (p *explainPlan) Label() string {
+	label := p.NodeType
+	if p.JoinType != "" {
+		label += " (" + p.JoinType + ")"
+	} else if p.Strategy != "" {
+		label += " (" + p.Strategy + ")"
+	}
+	if p.RelationName != "" {
+		label += " on " + p.RelationName
+	}
+	if p.IndexName != "" {
+		label += " using " + p.IndexName
+	}
+	return label
+}
+
+// planDiff returns the differences between the structure of the plans old
+// and cur, one line per added (+), removed (-) or changed (~) node, indented
+// by depth. Child nodes are matched by their position.
+func planDiff(old, cur *explainPlan) []string {
+	var lines []string
+	var diff func(old, cur *explainPlan, depth int)
+	listNodes := func(p *explainPlan, prefix string, depth int) {
+		p.walkDepth(depth, func(node *explainPlan, depth int) {
+			lines = append(lines, fmt.Sprintf("%s%s %s", strings.Repeat("  ", depth), prefix, node.Label()))
+		})
+	}
+	diff = func(old, cur *explainPlan, depth int) {
+		if old.Label() != cur.Label() {
+			lines = append(lines, fmt.Sprintf("%s~ %s -> %s", strings.Repeat("  ", depth), old.Label(), cur.Label()))
+		}
+		for i := 0; i < len(old.Plans) || i < len(cur.Plans); i++ {
+			switch {
+			case i >= len(cur.Plans):
+				listNodes(old.Plans[i], "-", depth+1)
+			case i >= len(old.Plans):
+				listNodes(cur.Plans[i], "+", depth+1)
+			default:
+				diff(old.Plans[i], cur.Plans[i], depth+1)
+			}
+		}
+	}
+	diff(old, cur, 0)
+	return lines
+}
+
+// verbosePlan returns the nodes of p one per line, indented by depth, each
+// followed by its output columns, conditions and the rows removed by its
+// filter, see -explain-verbose.
+func verbosePlan(p *explainPlan) []string {
+	var lines []string
+	p.walkDepth(0, func(node *explainPlan, depth int) {
+		indent := strings.Repeat("  ", depth)
+		lines = append(lines, indent+"-> "+node.Label())
+		if len(node.Output) > 0 {
+			lines = append(lines, indent+"     Output: "+strings.Join(node.Output, ", "))
+		}
+		if node.IndexCond != "" {
+			lines = append(lines, indent+"     Index Cond: "+node.IndexCond)
+		}
+		if node.Filter != "" {
+			lines = append(lines, indent+"     Filter: "+node.Filter)
+			lines = append(lines, fmt.Sprintf("%s     Rows Removed by Filter: %g", indent, node.RowsRemovedByFilter))
+		}
+	})
+	return lines
+}
+
+// walkDepth is like Walk, but also passes the depth of each node, starting at
+// depth for p.
+func (p *explainPlan) walkDepth(depth int, fn func(node *explainPlan, depth int)) {
+	fn(p, depth)
+	for _, child := range p.Plans {
+		child.walkDepth(depth+1, fn)
+	}
+}
+
+// writePlans writes the most recent plan of all queries to path as a JSON
+// object keyed by query name, see -plans-out.
+func writePlans(path string, queries []*Query) error {
+	plans := map[string]*explainPlan{}
+	for _, q := range queries {
+		if q.Plan != nil {
+			plans[q.Name] = q.Plan
+		}
+	}
+	data, err := json.MarshalIndent(plans, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0666)
+}
+
+// readPlans reads the plans written by writePlans from path.
+func readPlans(path string) (map[string]*explainPlan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plans map[string]*explainPlan
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plans, nil
+}
+
+// textPlan executes query once via EXPLAIN (ANALYZE) and returns its plan in
+// the TEXT format, which is more readable than JSON, see -text-plans. Queries
+// with a "-- params:" directive are explained via a prepared statement.
+func textPlan(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) (string, error) {
+	options := "ANALYZE"
+	if opts.Buffers {
+		options += ", BUFFERS"
+	}
+	if _, ok := queryDirective(query, "params"); ok {
+		name := nextStatementName()
+		prepareSQL, executeSQL := prepareStatements(name, query)
+		if _, err := conn.ExecContext(ctx, prepareSQL); err != nil {
+			return "", err
+		}
+		defer conn.ExecContext(ctx, "DEALLOCATE "+name)
+		query = executeSQL
+	}
+
+	rows, err := conn.QueryContext(ctx, "EXPLAIN ("+options+") "+query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainPlan_Fingerprint(t *testing.T) {
+	parse := func(planJSON string) *explainPlan {
+		t.Helper()
+		var plan explainPlan
+		if err := json.Unmarshal([]byte(planJSON), &plan); err != nil {
+			t.Fatal(err)
+		}
+		return &plan
+	}
+
+	seqScan := parse(`{"Node Type": "Aggregate", "Actual Rows": 1, "Plans": [{"Node Type": "Seq Scan", "Relation Name": "t", "Actual Rows": 100}]}`)
+	seqScan2 := parse(`{"Node Type": "Aggregate", "Actual Rows": 1, "Plans": [{"Node Type": "Seq Scan", "Relation Name": "t", "Actual Rows": 99}]}`)
+	indexScan := parse(`{"Node Type": "Aggregate", "Actual Rows": 1, "Plans": [{"Node Type": "Index Scan", "Relation Name": "t", "Index Name": "t_pkey", "Actual Rows": 100}]}`)
+
+	if seqScan.Fingerprint() != seqScan2.Fingerprint() {
+		t.Error("expected same fingerprint for different row counts")
+	}
+	if seqScan.Fingerprint() == indexScan.Fingerprint() {
+		t.Error("expected different fingerprint for different plans")
+	}
+}
+
+func TestExplainPlan_SeqScans(t *testing.T) {
+	var plan explainPlan
+	planJSON := `{"Node Type": "Nested Loop", "Actual Rows": 10, "Actual Loops": 1, "Plans": [
+		{"Node Type": "Seq Scan", "Relation Name": "small", "Actual Rows": 10, "Actual Loops": 1},
+		{"Node Type": "Seq Scan", "Relation Name": "big", "Actual Rows": 1, "Rows Removed by Filter": 99, "Actual Loops": 10}
+	]}`
+	if err := json.Unmarshal([]byte(planJSON), &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	scans := plan.SeqScans(1000)
+	if len(scans) != 1 || scans[0].RelationName != "big" {
+		t.Fatalf("got %v, want only big", scans)
+	}
+	if got := scans[0].ScannedRows(); got != 1000 {
+		t.Errorf("got %g scanned rows, want 1000", got)
+	}
+	if got := plan.SeqScans(1); len(got) != 2 {
+		t.Errorf("got %d seq scans, want 2", len(got))
+	}
+
+	var base explainPlan
+	baseJSON := `{"Node Type": "Seq Scan", "Relation Name": "small", "Actual Rows": 1, "Actual Loops": 1}`
+	if err := json.Unmarshal([]byte(baseJSON), &base); err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.NewSeqScans(&base, 1); len(got) != 1 || got[0].RelationName != "big" {
+		t.Errorf("got %v, want only big", got)
+	}
+	if got := plan.NewSeqScans(&plan, 1); len(got) != 0 {
+		t.Errorf("got %v, want none", got)
+	}
+}
+
+func TestExplainPlan_Spills(t *testing.T) {
+	tests := []struct {
+		PlanJSON   string
+		WantSpills bool
+		WantBlocks float64
+	}{
+		{`{"Node Type": "Sort", "Sort Space Type": "Memory"}`, false, -1},
+		{`{"Node Type": "Limit", "Plans": [{"Node Type": "Sort", "Sort Space Type": "Disk"}]}`, true, -1},
+		{`{"Node Type": "Hash Join", "Plans": [{"Node Type": "Hash", "Hash Batches": 4}]}`, true, -1},
+		{`{"Node Type": "Sort", "Temp Read Blocks": 0, "Temp Written Blocks": 0}`, false, 0},
+		{`{"Node Type": "Aggregate", "Temp Read Blocks": 10, "Temp Written Blocks": 12}`, true, 22},
+	}
+	for _, test := range tests {
+		var plan explainPlan
+		if err := json.Unmarshal([]byte(test.PlanJSON), &plan); err != nil {
+			t.Fatal(err)
+		}
+		if got := plan.Spills(); got != test.WantSpills {
+			t.Errorf("%s: got=%t want=%t", test.PlanJSON, got, test.WantSpills)
+		}
+		blocks, ok := plan.TempBlocks()
+		if !ok {
+			blocks = -1
+		}
+		if blocks != test.WantBlocks {
+			t.Errorf("%s: got=%g want=%g", test.PlanJSON, blocks, test.WantBlocks)
+		}
+	}
+}
+
+func TestExplainPlan_RowEstimateError(t *testing.T) {
+	tests := []struct {
+		PlanJSON string
+		Want     float64
+	}{
+		{`{"Node Type": "Seq Scan", "Plan Rows": 100, "Actual Rows": 100}`, 1},
+		{`{"Node Type": "Seq Scan", "Plan Rows": 10, "Actual Rows": 1000}`, 100},
+		{`{"Node Type": "Seq Scan", "Plan Rows": 500, "Actual Rows": 50}`, 10},
+		{`{"Node Type": "Seq Scan", "Plan Rows": 5, "Actual Rows": 0}`, 5},
+	}
+	for _, test := range tests {
+		var plan explainPlan
+		if err := json.Unmarshal([]byte(test.PlanJSON), &plan); err != nil {
+			t.Fatal(err)
+		}
+		if got := plan.RowEstimateError(); got != test.Want {
+			t.Errorf("%s: got=%g want=%g", test.PlanJSON, got, test.Want)
+		}
+	}
+}
+
+func Test_planDiff(t *testing.T) {
+	var old, cur explainPlan
+	oldJSON := `{"Node Type": "Nested Loop", "Join Type": "Inner", "Plans": [
+		{"Node Type": "Index Scan", "Relation Name": "a", "Index Name": "a_pkey"},
+		{"Node Type": "Index Scan", "Relation Name": "b", "Index Name": "b_pkey"}
+	]}`
+	curJSON := `{"Node Type": "Hash Join", "Join Type": "Inner", "Plans": [
+		{"Node Type": "Seq Scan", "Relation Name": "a"},
+		{"Node Type": "Hash", "Plans": [{"Node Type": "Seq Scan", "Relation Name": "b"}]},
+		{"Node Type": "Result"}
+	]}`
+	if err := json.Unmarshal([]byte(oldJSON), &old); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal([]byte(curJSON), &cur); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"~ Nested Loop (Inner) -> Hash Join (Inner)",
+		"  ~ Index Scan on a using a_pkey -> Seq Scan on a",
+		"  ~ Index Scan on b using b_pkey -> Hash",
+		"    + Seq Scan on b",
+		"  + Result",
+	}, "\n")
+	if got := strings.Join(planDiff(&old, &cur), "\n"); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	} else if diff := planDiff(&old, &old); len(diff) != 0 {
+		t.Fatalf("expected no diff, got %q", diff)
+	}
+}
+
+func Test_verbosePlan(t *testing.T) {
+	var plan explainPlan
+	planJSON := `{"Node Type": "Nested Loop", "Join Type": "Inner", "Output": ["a.id", "b.name"], "Plans": [
+		{"Node Type": "Seq Scan", "Relation Name": "a", "Output": ["a.id"], "Filter": "(a.active)", "Rows Removed by Filter": 42},
+		{"Node Type": "Index Scan", "Relation Name": "b", "Index Name": "b_pkey", "Output": ["b.name"], "Index Cond": "(b.id = a.id)"}
+	]}`
+	if err := json.Unmarshal([]byte(planJSON), &plan); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"-> Nested Loop (Inner)",
+		"     Output: a.id, b.name",
+		"  -> Seq Scan on a",
+		"       Output: a.id",
+		"       Filter: (a.active)",
+		"       Rows Removed by Filter: 42",
+		"  -> Index Scan on b using b_pkey",
+		"       Output: b.name",
+		"       Index Cond: (b.id = a.id)",
+	}, "\n")
+	if got := strings.Join(verbosePlan(&plan), "\n"); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_writePlans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plans.json")
+	plan := &explainPlan{NodeType: "Seq Scan", RelationName: "t", ActualRows: 3}
+	if err := writePlans(path, []*Query{{Name: "a", Plan: plan}, {Name: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	plans, err := readPlans(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(plans) != 1 || plans["a"].Fingerprint() != plan.Fingerprint() {
+		t.Fatalf("unexpected plans: %v", plans)
+	}
+}
@@ -3,8 +3,10 @@ module github.com/felixge/sqlbench
 go 1.16
 
 require (
+	github.com/jackc/pgconn v1.6.4
 	github.com/jackc/pgx/v4 v4.8.1
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/montanaflynn/stats v0.6.3
 	github.com/olekukonko/tablewriter v0.0.4
+	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
 )
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+)
+
+// writeHistogramCSV writes a histogram of the measurements of each query to
+// csvPath. All queries share the same buckets, so the resulting counts can be
+// plotted against each other directly.
+func writeHistogramCSV(csvPath string, queries []*Query, buckets int) error {
+	var (
+		min, max float64
+		seen     bool
+	)
+	for _, query := range queries {
+		if len(query.Seconds) == 0 {
+			continue
+		}
+		if !seen || query.Min < min {
+			min = query.Min
+		}
+		if !seen || query.Max > max {
+			max = query.Max
+		}
+		seen = true
+	}
+
+	file, err := os.Create(csvPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cw := csv.NewWriter(file)
+	if err := cw.Write([]string{"query", "bucket_seconds", "count"}); err != nil {
+		return err
+	}
+	width := (max - min) / float64(buckets)
+	for _, query := range queries {
+		for i, count := range histogram(query.Seconds, min, max, buckets) {
+			record := []string{
+				query.Name,
+				fmt.Sprintf("%f", min+float64(i)*width),
+				fmt.Sprintf("%d", count),
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// histogram returns the number of values falling into each of the given
+// number of equally sized buckets spanning [min, max]. Values outside of this
+// range are counted towards the first or last bucket.
+func histogram(values []float64, min, max float64, buckets int) []int {
+	counts := make([]int, buckets)
+	width := (max - min) / float64(buckets)
+	for _, v := range values {
+		i := 0
+		if width > 0 {
+			i = int((v - min) / width)
+		}
+		if i < 0 {
+			i = 0
+		} else if i >= buckets {
+			i = buckets - 1
+		}
+		counts[i]++
+	}
+	return counts
+}
+
+const (
+	// histogramPrecision is the relative width of the durationHistogram
+	// buckets, which bounds the relative error of its quantiles to half of it.
+	histogramPrecision = 0.01
+	// histogramMinSeconds and histogramMaxSeconds are the range of durations
+	// the durationHistogram buckets cover. Durations outside of it are counted
+	// towards the first or last bucket.
+	histogramMinSeconds = 1e-9
+	histogramMaxSeconds = 3600
+)
+
+// histogramBuckets is the number of logarithmic buckets needed for covering
+// the histogram range with the histogramPrecision.
+var histogramBuckets = int(math.Log(histogramMaxSeconds/histogramMinSeconds)/math.Log1p(histogramPrecision)) + 1
+
+// durationHistogram records durations in seconds using a fixed amount of
+// memory, see -histogram-after. Like an HDR histogram it uses logarithmic
+// buckets, so quantiles are estimated with a bounded relative error. The
+// count, min, max, mean and variance are exact.
+type durationHistogram struct {
+	counts []int64
+	Count  int64
+	Min    float64
+	Max    float64
+	// mean and m2 are updated via Welford's algorithm, which is numerically
+	// stable for large counts.
+	mean float64
+	m2   float64
+}
+
+func newDurationHistogram() *durationHistogram {
+	return &durationHistogram{counts: make([]int64, histogramBuckets)}
+}
+
+// Record adds the duration in seconds to the histogram.
+func (h *durationHistogram) Record(seconds float64) {
+	if h.Count == 0 || seconds < h.Min {
+		h.Min = seconds
+	}
+	if h.Count == 0 || seconds > h.Max {
+		h.Max = seconds
+	}
+	h.Count++
+	delta := seconds - h.mean
+	h.mean += delta / float64(h.Count)
+	h.m2 += delta * (seconds - h.mean)
+	h.counts[histogramBucket(seconds)]++
+}
+
+// Mean returns the mean of the recorded durations.
+func (h *durationHistogram) Mean() float64 {
+	return h.mean
+}
+
+// StdDev returns the sample standard deviation of the recorded durations.
+func (h *durationHistogram) StdDev() float64 {
+	if h.Count < 2 {
+		return 0
+	}
+	return math.Sqrt(h.m2 / float64(h.Count-1))
+}
+
+// Percentile estimates the p-th percentile of the recorded durations as the
+// geometric center of the bucket holding it, clamped to [Min, Max].
+func (h *durationHistogram) Percentile(p float64) float64 {
+	rank := int64(math.Ceil(p / 100 * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			v := histogramMinSeconds * math.Pow(1+histogramPrecision, float64(i)+0.5)
+			return math.Min(math.Max(v, h.Min), h.Max)
+		}
+	}
+	return h.Max
+}
+
+// histogramBucket returns the index of the durationHistogram bucket for the
+// given duration in seconds.
+func histogramBucket(seconds float64) int {
+	if seconds <= histogramMinSeconds {
+		return 0
+	}
+	i := int(math.Log(seconds/histogramMinSeconds) / math.Log1p(histogramPrecision))
+	if i >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return i
+}
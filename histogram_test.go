@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+func Test_histogram(t *testing.T) {
+	got := histogram([]float64{1, 1.5, 2, 2.9, 3, 4}, 1, 4, 3)
+	if want := []int{2, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+
+	got = histogram([]float64{1, 1}, 1, 1, 2)
+	if want := []int{2, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+}
+
+func Test_durationHistogram(t *testing.T) {
+	h := newDurationHistogram()
+	var seconds []float64
+	for i := 1; i <= 1000; i++ {
+		s := float64(i) / 1e4
+		seconds = append(seconds, s)
+		h.Record(s)
+	}
+	mean, _ := stats.Mean(seconds)
+	stddev, _ := stats.StdDevS(seconds)
+	if h.Count != 1000 || h.Min != 1e-4 || h.Max != 0.1 {
+		t.Fatalf("unexpected count, min or max: %+v", h)
+	} else if math.Abs(h.Mean()-mean) > 1e-12 || math.Abs(h.StdDev()-stddev) > 1e-12 {
+		t.Fatalf("got mean=%g stddev=%g want mean=%g stddev=%g", h.Mean(), h.StdDev(), mean, stddev)
+	}
+	for _, p := range []float64{1, 50, 90, 99, 100} {
+		want, _ := stats.Percentile(seconds, p)
+		if got := h.Percentile(p); math.Abs(got-want)/want > histogramPrecision {
+			t.Errorf("p%g: got=%g want=%g", p, got, want)
+		}
+	}
+}
+
+func TestQuery_histogramAfter(t *testing.T) {
+	defer func(old int) { histogramAfter = old }(histogramAfter)
+	histogramAfter = 10
+
+	q := &Query{}
+	for i := int64(1); i <= 100; i++ {
+		q.AddSample(i, measurement{Duration: time.Duration(i) * time.Millisecond, Rows: -1, Planning: -1, Execution: -1, FirstRow: -1})
+	}
+	if err := q.UpdateStats(); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Seconds) != 10 || q.N() != 100 {
+		t.Fatalf("got %d stored samples and n=%d", len(q.Seconds), q.N())
+	} else if q.Max != 0.1 || q.MaxIteration != 100 || math.Abs(q.Mean-0.0505) > 1e-12 {
+		t.Fatalf("got max=%g max iter=%d mean=%g", q.Max, q.MaxIteration, q.Mean)
+	} else if math.Abs(q.Median-0.05)/0.05 > histogramPrecision {
+		t.Fatalf("got median=%g", q.Median)
+	}
+	if p25, p75, err := quartiles(q); err != nil {
+		t.Fatal(err)
+	} else if math.Abs(p25-0.025)/0.025 > histogramPrecision || math.Abs(p75-0.075)/0.075 > histogramPrecision {
+		t.Fatalf("got p25=%g p75=%g", p25, p75)
+	}
+}
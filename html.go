@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+// htmlReport is the data of the -html-out report template.
+type htmlReport struct {
+	Generated string
+	RunTime   string
+	Headers   []string
+	Rows      [][]string
+	Summary   string
+	Unit      string
+	Chart     htmlChart
+}
+
+// htmlChart is an SVG box plot of the durations of all queries.
+type htmlChart struct {
+	Width, Height int
+	Boxes         []htmlBox
+	Ticks         []htmlTick
+}
+
+// htmlBox is the box plot of a single query. All coordinates are in pixels.
+type htmlBox struct {
+	Name                  string
+	Y                     int
+	Min, P25, Median, P75 float64
+	Max, Mean             float64
+	// MinValue, MedianValue and MaxValue are the durations in the unit
+	// of the report.
+	MinValue, MedianValue, MaxValue float64
+}
+
+// BoxWidth returns the width of the box spanning P25 to P75.
+func (b htmlBox) BoxWidth() float64 {
+	return b.P75 - b.P25
+}
+
+// htmlTick is a label on the x axis of the chart.
+type htmlTick struct {
+	X     float64
+	Label string
+}
+
+const (
+	// htmlLabelWidth is the space reserved for the query names in the chart.
+	htmlLabelWidth = 200
+	// htmlPlotWidth is the width of the plot area of the chart.
+	htmlPlotWidth = 600
+	// htmlRowHeight is the height of every box in the chart.
+	htmlRowHeight = 40
+	// htmlTicks is the number of ticks on the x axis of the chart.
+	htmlTicks = 5
+)
+
+// writeHTMLReport writes a self-contained HTML page with the stats table and
+// a box plot of the durations of queries to path, see -html-out. runTime is
+// included in the report as well.
+func writeHTMLReport(path string, queries []*Query, opts renderOptions, runTime runTimes) error {
+	unit := resolveUnit(opts.Unit, queries)
+	report := htmlReport{
+		Generated: time.Now().Format(time.RFC1123),
+		RunTime:   runTime.String(),
+		Unit:      unit.Long,
+	}
+
+	stats := displayStats(queries, opts)
+	report.Headers = []string{""}
+	for _, stat := range stats {
+		report.Headers = append(report.Headers, stat.Name)
+	}
+	for i, cells := range formatCells(queries, stats, opts) {
+		report.Rows = append(report.Rows, append([]string{opts.queryLabel(queries[i].Name)}, cells...))
+	}
+	if len(opts.Baseline) > 0 {
+		report.Summary = suiteSummary(queries, opts.Baseline, opts)
+	}
+
+	chart, err := newHTMLChart(queries, unit)
+	if err != nil {
+		return err
+	}
+	report.Chart = chart
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := htmlTemplate.Execute(file, report); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// newHTMLChart returns the box plot of the durations of queries. The whiskers
+// span from the min to the max duration, the box from the 25th to the 75th
+// percentile. The mean is shown as a dot. Durations are labeled in unit.
+func newHTMLChart(queries []*Query, unit timeUnit) (htmlChart, error) {
+	chart := htmlChart{
+		Width:  htmlLabelWidth + htmlPlotWidth + 60,
+		Height: len(queries)*htmlRowHeight + 30,
+	}
+	var max float64
+	for _, q := range queries {
+		if q.Max > max {
+			max = q.Max
+		}
+	}
+	if max == 0 {
+		return chart, nil
+	}
+	x := func(seconds float64) float64 {
+		return htmlLabelWidth + seconds/max*htmlPlotWidth
+	}
+
+	for i, q := range queries {
+		if len(q.Seconds) == 0 {
+			continue
+		}
+		p25, p75, err := quartiles(q)
+		if err != nil {
+			return chart, err
+		}
+		chart.Boxes = append(chart.Boxes, htmlBox{
+			Name:        q.Name,
+			Y:           i * htmlRowHeight,
+			Min:         x(q.Min),
+			P25:         x(p25),
+			Median:      x(q.Median),
+			P75:         x(p75),
+			Max:         x(q.Max),
+			Mean:        x(q.Mean),
+			MinValue:    q.Min * unit.Scale,
+			MedianValue: q.Median * unit.Scale,
+			MaxValue:    q.Max * unit.Scale,
+		})
+	}
+	for i := 0; i <= htmlTicks; i++ {
+		seconds := max * float64(i) / htmlTicks
+		chart.Ticks = append(chart.Ticks, htmlTick{
+			X:     x(seconds),
+			Label: fmt.Sprintf("%.2f %s", seconds*unit.Scale, unit.Name),
+		})
+	}
+	return chart, nil
+}
+
+// quartiles returns the 25th and 75th percentile of the durations of q. They
+// are estimated from its Hist if it has one, see -histogram-after.
+func quartiles(q *Query) (p25, p75 float64, err error) {
+	if q.Hist != nil {
+		return q.Hist.Percentile(25), q.Hist.Percentile(75), nil
+	} else if p25, err = stats.PercentileNearestRank(q.Seconds, 25); err != nil {
+		return 0, 0, err
+	}
+	p75, err = stats.PercentileNearestRank(q.Seconds, 75)
+	return p25, p75, err
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>sqlbench report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; white-space: nowrap; }
+th { background: #f4f4f4; }
+svg text { font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>sqlbench report</h1>
+<p>Generated {{.Generated}}. {{.RunTime}} All durations are in {{.Unit}}.</p>
+<table>
+<tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{with .Summary}}<p>{{.}}</p>{{end}}
+<h2>Durations</h2>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Chart.Width}}" height="{{.Chart.Height}}">
+{{range .Chart.Boxes}}<g>
+<title>{{.Name}}: min {{printf "%.2f" .MinValue}}, median {{printf "%.2f" .MedianValue}}, max {{printf "%.2f" .MaxValue}}</title>
+<text x="0" y="{{add .Y 24}}">{{.Name}}</text>
+<line x1="{{.Min}}" x2="{{.Max}}" y1="{{add .Y 20}}" y2="{{add .Y 20}}" stroke="#555"/>
+<rect x="{{.P25}}" y="{{add .Y 8}}" width="{{.BoxWidth}}" height="24" fill="#9ecae1" stroke="#3182bd"/>
+<line x1="{{.Median}}" x2="{{.Median}}" y1="{{add .Y 8}}" y2="{{add .Y 32}}" stroke="#08519c" stroke-width="2"/>
+<circle cx="{{.Mean}}" cy="{{add .Y 20}}" r="3" fill="#e6550d"/>
+</g>
+{{end}}{{$y := .Chart.Height}}{{range .Chart.Ticks}}<text x="{{.X}}" y="{{add $y -5}}" text-anchor="middle">{{.Label}}</text>
+{{end}}</svg>
+<p>Whiskers show the min and max, boxes the 25th to 75th percentile, the dark line the median and the dot the mean.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_writeHTMLReport(t *testing.T) {
+	queries := []*Query{
+		{Name: "fast", Seconds: []float64{1, 2, 3}},
+		{Name: "<slow>", Seconds: []float64{2, 4, 6}},
+	}
+	for _, q := range queries {
+		if err := q.UpdateStats(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "report.html")
+	if err := writeHTMLReport(path, queries, renderOptions{}, runTimes{Start: start, End: start.Add(90 * time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+	for _, want := range []string{"<svg", "<td>fast</td>", "&lt;slow&gt;", "2000.00 (2.00x)", "took 1m30s"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("missing %q in:\n%s", want, html)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// jsonlRecord is a single measurement written by jsonlWriter. Unlike the -o
+// CSV columns, fields can be added without breaking existing readers.
+type jsonlRecord struct {
+	Iteration int64   `json:"iteration"`
+	Query     string  `json:"query"`
+	Seconds   float64 `json:"seconds"`
+	SQLHash   string  `json:"sql_hash"`
+	// The fields below are only available for some methods.
+	PlanningSeconds  *float64 `json:"planning_seconds,omitempty"`
+	ExecutionSeconds *float64 `json:"execution_seconds,omitempty"`
+	FirstRowSeconds  *float64 `json:"first_row_seconds,omitempty"`
+	Rows             *float64 `json:"rows,omitempty"`
+	Plan             string   `json:"plan,omitempty"`
+	// Labels are the -label values of the run.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// newJSONLRecord returns the jsonlRecord for measurement m of query q.
+func newJSONLRecord(iteration int64, q *Query, m measurement) jsonlRecord {
+	r := jsonlRecord{
+		Iteration: iteration,
+		Query:     q.Name,
+		Seconds:   m.Duration.Seconds(),
+		SQLHash:   q.SQLHash,
+	}
+	if m.Planning >= 0 && m.Execution >= 0 {
+		planning, execution := m.Planning.Seconds(), m.Execution.Seconds()
+		r.PlanningSeconds, r.ExecutionSeconds = &planning, &execution
+	}
+	if m.FirstRow >= 0 {
+		firstRow := m.FirstRow.Seconds()
+		r.FirstRowSeconds = &firstRow
+	}
+	if m.Rows >= 0 {
+		rows := m.Rows
+		r.Rows = &rows
+	}
+	if m.Plan != nil {
+		r.Plan = m.Plan.Fingerprint()
+	}
+	return r
+}
+
+// jsonlWriter writes one JSON object per measurement to a file, see
+// -jsonl-out.
+type jsonlWriter struct {
+	file       *os.File
+	bw         *bufio.Writer
+	enc        *json.Encoder
+	flushEvery int
+	records    int
+	labels     map[string]string
+}
+
+// openJSONLWriter creates or truncates the file at path. The records are
+// flushed to disk after every flushEvery records, or only when closing if
+// flushEvery is 0. All records are tagged with the given labels, if any.
+func openJSONLWriter(path string, flushEvery int, labels map[string]string) (*jsonlWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(file)
+	return &jsonlWriter{file: file, bw: bw, enc: json.NewEncoder(bw), flushEvery: flushEvery, labels: labels}, nil
+}
+
+// Write writes r as a single line.
+func (w *jsonlWriter) Write(r jsonlRecord) error {
+	if len(w.labels) > 0 {
+		r.Labels = w.labels
+	}
+	if err := w.enc.Encode(r); err != nil {
+		return err
+	}
+	w.records++
+	if w.flushEvery > 0 && w.records%w.flushEvery == 0 {
+		return w.bw.Flush()
+	}
+	return nil
+}
+
+// Close flushes all buffered records and closes the underlying file.
+func (w *jsonlWriter) Close() error {
+	if err := w.bw.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// writeRawDurations writes the durations of all samples of queries in seconds
+// to path as a single JSON object keyed by query name, see -raw-out.
+func writeRawDurations(path string, queries []*Query) error {
+	raw := make(map[string][]float64, len(queries))
+	for _, q := range queries {
+		raw[q.Name] = append([]float64{}, q.Seconds...)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0666)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_jsonlWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	w, err := openJSONLWriter(path, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &Query{Name: "gauss", SQLHash: "abcd1234"}
+	client := measurement{Duration: 500 * time.Millisecond, Rows: -1, Planning: -1, Execution: -1, FirstRow: 100 * time.Millisecond}
+	explain := measurement{Duration: 250 * time.Millisecond, Rows: 3, Planning: time.Millisecond, Execution: 250 * time.Millisecond, FirstRow: -1}
+	if err := w.Write(newJSONLRecord(1, q, client)); err != nil {
+		t.Fatal(err)
+	} else if err := w.Write(newJSONLRecord(2, q, explain)); err != nil {
+		t.Fatal(err)
+	} else if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"iteration":1,"query":"gauss","seconds":0.5,"sql_hash":"abcd1234","first_row_seconds":0.1}
+{"iteration":2,"query":"gauss","seconds":0.25,"sql_hash":"abcd1234","planning_seconds":0.001,"execution_seconds":0.25,"rows":3}
+`
+	if got := string(data); got != want {
+		t.Fatalf("got=%s want=%s", got, want)
+	}
+}
+
+func Test_writeRawDurations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raw.json")
+	queries := []*Query{
+		{Name: "a", Seconds: []float64{0.5, 0.25}},
+		{Name: "b"},
+	}
+	if err := writeRawDurations(path, queries); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":[0.5,0.25],"b":[]}` + "\n"
+	if got := string(data); got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+}
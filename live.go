@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// liveWriter appends timestamped snapshots of the aggregated stats of all
+// queries to a CSV file, see -live-out. Unlike -o this produces a time series
+// of the running aggregates that can be watched by an external plotting tool.
+type liveWriter struct {
+	file *os.File
+	cw   *csv.Writer
+}
+
+// liveColumns returns the columns written by liveWriter, which include the
+// statPercentiles. All durations are in seconds.
+func liveColumns() []string {
+	columns := []string{"time", "query", "n", "mean", "stddev", "median"}
+	for _, p := range statPercentiles {
+		columns = append(columns, percentileName(p))
+	}
+	return columns
+}
+
+// openLiveWriter opens the file at path for appending and writes the CSV
+// header if the file is empty. Otherwise the existing header must match the
+// liveColumns, which depend on -percentiles.
+func openLiveWriter(path string) (*liveWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	w := &liveWriter{file: file, cw: csv.NewWriter(file)}
+	if info, err := file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	} else if info.Size() == 0 {
+		w.cw.Write(liveColumns())
+		if w.cw.Flush(); w.cw.Error() != nil {
+			file.Close()
+			return nil, w.cw.Error()
+		}
+	} else if header, err := csv.NewReader(file).Read(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	} else if got, want := strings.Join(header, ","), strings.Join(liveColumns(), ","); got != want {
+		file.Close()
+		return nil, fmt.Errorf("%s: can't append to a file with different columns: got %q want %q", path, got, want)
+	}
+	return w, nil
+}
+
+// Write appends the current stats of all queries using the timestamp t and
+// flushes them to disk.
+func (w *liveWriter) Write(t time.Time, queries []*Query) error {
+	for _, q := range queries {
+		record := []string{
+			t.Format(time.RFC3339Nano),
+			q.Name,
+			fmt.Sprintf("%d", q.N()),
+			fmt.Sprintf("%f", q.Mean),
+			fmt.Sprintf("%f", q.StdDev),
+			fmt.Sprintf("%f", q.Median),
+		}
+		for i := range statPercentiles {
+			record = append(record, fmt.Sprintf("%f", q.percentile(i)))
+		}
+		if err := w.cw.Write(record); err != nil {
+			return err
+		}
+	}
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+// Close closes the underlying file.
+func (w *liveWriter) Close() error {
+	return w.file.Close()
+}
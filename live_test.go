@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_openLiveWriter(t *testing.T) {
+	defer func(old []float64) { setPercentiles(old) }(statPercentiles)
+	path := filepath.Join(t.TempDir(), "live.csv")
+
+	for i := 0; i < 2; i++ {
+		w, err := openLiveWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(data), "time,query,n,mean,stddev,median,p90,p95\n"; got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+
+	setPercentiles([]float64{99})
+	if _, err := openLiveWriter(path); err == nil {
+		t.Fatal("expected error for different percentiles")
+	}
+}
@@ -3,22 +3,34 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 	"github.com/montanaflynn/stats"
-	"github.com/olekukonko/tablewriter"
 )
 
 const version = "1.1"
@@ -31,6 +43,39 @@ func main() {
 }
 
 func run() error {
+	var setF stringList
+	flag.Var(&setF, "set", strings.TrimSpace(`
+Session setting to apply after init.sql via set_config(), e.g. work_mem=64MB.
+The value is passed as is, so list settings like search_path=a,b work. Can be
+given multiple times. The settings apply to all connections used for the
+benchmark.
+`))
+
+	var dbF stringList
+	flag.Var(&dbF, "db", strings.TrimSpace(`
+Connection URL or DSN of a database to benchmark instead of -c. Can be given
+multiple times for comparing shards or a primary and its replica, in which case
+every query is executed against every database and displayed as query@db,
+where db is the database name, or its position if the names aren't unique.
+init.sql and destroy.sql are executed on every database.
+`))
+
+	var labelF stringList
+	flag.Var(&labelF, "label", strings.TrimSpace(`
+Label in key=value form that is recorded with the results, e.g. sha=abc123.
+Can be given multiple times. Labels are written to the -o metadata, every
+-jsonl-out record and the -results-db, which ties the results to the code
+state that produced them.
+`))
+
+	var workloadF stringList
+	flag.Var(&workloadF, "workload", strings.TrimSpace(`
+Workload in name=a.sql,b.sql form whose queries are executed one after another
+on the same connection and measured as a single unit, e.g. for a request flow
+of dependent reads and writes. Every iteration records the total time of all
+queries as one sample of name. Can be given multiple times.
+`))
+
 	var (
 		methodF = flag.String("m", "explain", "Method for measuring the query time. One of: "+queryDurationMethods())
 		connF   = flag.String("c", "postgres://", strings.TrimSpace(`
@@ -43,20 +88,425 @@ PGHOST, PGPORT, PGPASSWORD, ... .
 [1] https://pkg.go.dev/github.com/jackc/pgx/v4/stdlib?tab=doc
 [2] https://www.postgresql.org/docs/current/libpq-envars.html
 `)+"\n")
-		inCsvF      = flag.String("i", "", "Input path for CSV file with baseline measurements.")
-		outCsvF     = flag.String("o", "", "Output path for writing individual measurements in CSV format.")
+		liveOutF = flag.String("live-out", "", strings.TrimSpace(`
+Output path for appending timestamped snapshots of the aggregated stats of all
+queries in CSV format at every screen refresh. Useful for watching the stats
+drift over time with an external plotting tool. An existing file must have
+been written with the same -percentiles.
+`))
+		onlyF            = flag.String("only", "", "Comma separated list of query names to benchmark. Supports glob patterns such as 'sum_*'.")
+		excludeF         = flag.String("exclude", "", "Comma separated list of query names to exclude from the benchmark. Supports glob patterns.")
+		nameFromCommentF = flag.Bool("name-from-comment", false, strings.TrimSpace(`
+Name queries after a "-- name: Descriptive Name" comment at the top of their
+file instead of the file name, which is used for files without the comment.
+-only and -exclude match the new names.
+`))
+		connFileF = flag.String("conn-file", "", strings.TrimSpace(`
+Path of a file containing the -c connection URL or DSN, or "-" for reading it
+from stdin. Avoids leaking passwords into the shell history or process list.
+`))
+		initF = flag.String("init", "", strings.TrimSpace(`
+Path of a SQL file that is executed once before the benchmark. When -init or
+-destroy is given, files named init.sql or destroy.sql are benchmarked like
+any other query.
+`))
+		destroyF      = flag.String("destroy", "", "Path of a SQL file that is executed once after the benchmark, see -init.")
+		analyzeFirstF = flag.Bool("analyze-first", false, strings.TrimSpace(`
+Run ANALYZE after init.sql and -set, so the planner has fresh statistics and
+the measured plans are the ones of a well maintained database.
+`))
+		analyzeTablesF = flag.String("analyze-tables", "", "Comma separated list of tables to ANALYZE instead of the whole database. Implies -analyze-first.")
+		inCsvF         = flag.String("i", "", "Input path or http(s) URL for CSV file with baseline measurements.")
+		compareF       = flag.String("compare", "", strings.TrimSpace(`
+Input path or http(s) URL for a CSV file with measurements to compare against
+the -i baseline without connecting to the database or running any queries.
+`))
+		histOutF     = flag.String("hist-out", "", "Output path for writing a histogram of the measurements of each query in CSV format.")
+		histBucketsF = flag.Int("hist-buckets", 20, "Number of buckets for -hist-out.")
+		statsF       = flag.String("stats", "", strings.TrimSpace(`
+Comma separated list of stats to display, in the given order, e.g.
+n,median,p95. Defaults to all stats except for `+hiddenTableStatNames()+`.
+One of: `+tableStatNames()+`.
+`))
+		sortF = flag.String("sort", "mean", strings.TrimSpace(`
+Stat used for ordering the queries, e.g. p95 for optimizing tail latency. The
+first query is the reference for the ratios of the other queries, unless -i
+or -baseline-query is given. One of the -stats.
+`))
+		compareStatF = flag.String("compare-stat", "", strings.TrimSpace(`
+Stat used for a single headline ratio between each query and its reference
+query, e.g. median for a ratio that is robust against outliers. Replaces the
+ratios of the individual stats, and is also used by -matrix and the overall
+baseline summary instead of the mean. One of the -stats.
+`))
+		orderFileF = flag.String("order-file", "", strings.TrimSpace(`
+Path of a file listing query names one per line. Queries are displayed in this
+order instead of being sorted by -sort. Queries that aren't listed are
+displayed last, listed names without a query are ignored.
+`))
+		percentilesF = flag.String("percentiles", "90,95", "Comma separated list of percentiles to compute for each query, e.g. 50,99,99.9.")
+		trimPercentF = flag.Float64("trim-percent", 0, strings.TrimSpace(`
+Percentage of the samples to discard from each tail for the additional
+"trimmed mean" and "trimmed stddev" stats, e.g. 5 for discarding the fastest
+and slowest 5%. Reduces the influence of outliers in noisy environments.
+`))
+		histogramAfterF = flag.Int("histogram-after", 0, strings.TrimSpace(`
+Stop storing the individual durations of a query once it has the given number
+of samples, and record further durations into a fixed-memory histogram
+instead. Allows practically unbounded runs: n, min, max, mean and stddev stay
+exact, while the median and percentiles are estimated within 0.5%. The other
+stats, as well as -hist-out, only cover the stored samples. 0 stores all
+durations. Can't be combined with -paired, -rate, -raw-out or -f benchstat.
+`))
+		baselineQueryF = flag.String("baseline-query", "", strings.TrimSpace(`
+Name of the query that all other queries are compared against. Defaults to the
+fastest query, or the same query in the -i baseline. When combined with -i,
+the named query is taken from the baseline.
+`))
+		baselineLabelF = flag.String("baseline-label", "", strings.TrimSpace(`
+Name of the -i baseline, e.g. "main" or "pg13", which is used instead of "the
+baseline" when describing the ratios and the overall summary.
+`))
+		currentLabelF = flag.String("current-label", "", strings.TrimSpace(`
+Name of the current results when comparing against a -i baseline, e.g. "my
+branch" or "pg14". The query names are annotated with it, e.g. "q1 [pg14]".
+`))
+		absDeltaF = flag.Bool("abs-delta", false, strings.TrimSpace(`
+Annotate the duration ratios with the absolute difference to the compared
+query in the -unit as well, e.g. "(+2.30ms, 1.25x)". Unlike the ratio this
+shows whether a difference matters in wall-clock terms.
+`))
+		htmlOutF = flag.String("html-out", "", strings.TrimSpace(`
+Output path for writing a self-contained HTML report with the stats table and
+a box plot of the query durations, e.g. for sharing results.
+`))
+		tableOutF = flag.String("table-out", "", strings.TrimSpace(`
+Output path for writing the final stats table in CSV format, with a row for
+every query and a column for every stat and its ratio, e.g. for pasting a
+comparison into a spreadsheet. Unlike -o it holds the aggregated stats rather
+than the individual measurements. Also works with -compare.
+`))
+		resultsDBF = flag.String("results-db", "", strings.TrimSpace(`
+Path of a SQLite database that the final stats of every run are appended to,
+for tracking the performance history. The runs and query_stats tables are
+created if needed. Requires the sqlite3 command line tool.
+`))
+		gitSHAF   = flag.String("git-sha", "", "Git commit SHA stored with the run in the -results-db.")
+		plansOutF = flag.String("plans-out", "", strings.TrimSpace(`
+Output path for writing the most recent plan of every query as JSON, e.g. for
+comparing them against a later run via -plan-diff. Requires -m explain.
+`))
+		planDiffF = flag.String("plan-diff", "", strings.TrimSpace(`
+Input path of plans written by -plans-out. After the benchmark, the nodes that
+were added, removed or changed in the plan of every query are listed, e.g. an
+Index Scan that became a Seq Scan. Requires -m explain.
+`))
+		explainVerboseF = flag.Bool("explain-verbose", false, strings.TrimSpace(`
+Print the most recent plan of every query after the benchmark, including the
+output columns, Filter, Index Cond and Rows Removed by Filter of each node.
+Useful for understanding why queries with similar plans perform differently,
+e.g. for index tuning. Adds VERBOSE to the EXPLAIN options. Requires -m
+explain.
+`))
+		rawOutF = flag.String("raw-out", "", strings.TrimSpace(`
+Output path for writing the durations of all samples in seconds as a JSON
+object keyed by query name, e.g. for analyzing the distributions with numpy or
+R.
+`))
+		outCsvF = flag.String("o", "", strings.TrimSpace(`
+Output path for writing individual measurements in CSV format. The file starts
+with "# key: value" comment lines describing the run, e.g. the -m method, which
+are checked when using the file as -i baseline.
+`))
+		jsonlOutF = flag.String("jsonl-out", "", strings.TrimSpace(`
+Output path for writing individual measurements as JSON Lines, one object per
+measurement. Includes all of the -csv-columns as well as the planning and
+execution times of -m explain.
+`))
+		buffersSummaryF = flag.Bool("explain-buffers-summary", false, strings.TrimSpace(`
+Add BUFFERS to the EXPLAIN of -m explain and display the number of shared
+blocks read per returned row. This normalizes the I/O efficiency of queries
+returning different numbers of rows. The mean number of temporary blocks used
+by sorts or hashes spilling to disk is displayed as well.
+`))
+		csvColumnsF = flag.String("csv-columns", "", strings.TrimSpace(`
+Comma separated list of extra columns to include in the -o CSV file. One of:
+`+csvExtraColumnNames()+`. "sql_hash" is a short hash of the query's SQL,
+which -i uses for warning about queries that changed since the baseline. "rows"
+is the number of rows returned by the query and "plan" the fingerprint of its
+plan, both are only available for -m explain. Without any, the file only has
+the iteration, query and seconds columns.
+`))
+		flushEveryF = flag.Int("flush-every", 100, strings.TrimSpace(`
+Flush the -o and -jsonl-out files to disk after the given number of rows, so
+partial data survives a crash. 0 means only flushing when terminating.
+`))
 		iterationsF = flag.Int64("n", -1, "Terminate after the given number of iterations.")
 		secondsF    = flag.Float64("t", -1, "Terminate after the given number of seconds.")
-		planF       = flag.Bool("p", false, strings.TrimSpace(`
+		minSamplesF = flag.Int("min-samples", 0, strings.TrimSpace(`
+Keep going after -n or -t is reached until every query has at least the given
+number of samples, only executing the queries that are still lacking samples.
+Balances the -o output when some queries have fewer samples than others, e.g.
+due to -max-query-duration or -keep-going. Queries that get no new sample for
+10 consecutive iterations are reported as lacking samples instead.
+`))
+		planF = flag.Bool("p", false, strings.TrimSpace(`
 Include the query planning time. For -m explain this is accomplished by adding
 the "Planning Time" to the measurement. For -m client this is done by not using
-prepared statements.
+prepared statements. For -m prepare this is done by executing PREPARE and
+DEALLOCATE for every measurement. -m multi always includes the planning time.
+`))
+		targetRSEF = flag.Float64("target-rse", 0, strings.TrimSpace(`
+Terminate once the relative standard error (sem/mean) of every query is below
+the given percentage, e.g. 1 for 1%. Can be combined with -n or -t to limit
+the maximum duration. 0 disables this.
+`))
+		targetRSEMinF = flag.Int("target-rse-min", 10, "Minimum number of samples per query before -target-rse can terminate the benchmark.")
+		seedF         = flag.Int64("seed", 0, strings.TrimSpace(`
+Seed for the random values generated by query templates such as
+{{randint 1 1000}}, for reproducible runs. 0 picks a random seed.
+`))
+		preparedVsUnpreparedF = flag.Bool("prepared-vs-unprepared", false, strings.TrimSpace(`
+Measure every query twice, once as "name (prepared)" and once as "name
+(unprepared)" with the planning time included as if -p was given. This shows
+the planning overhead of each query in a single run. Can't be combined with
+-p, -pgbouncer or -m multi.
+`))
+		explainOnceF = flag.Bool("explain-once", false, strings.TrimSpace(`
+Run every query via EXPLAIN ANALYZE once before its first -m client
+measurement, which shows its plan and server side planning and execution time
+without paying the EXPLAIN overhead for every sample. Note that this executes
+data modifying queries once more as well. Requires -m client.
+`))
+		clientVsServerF = flag.Bool("client-vs-server", false, strings.TrimSpace(`
+Measure every query twice, once as "name (client)" via -m client and once as
+"name (server)" via -m explain, and list the client mean, the server mean and
+their difference after the benchmark. This shows how much of the latency is
+spent on the network and in the client rather than in the database. Requires
+-m explain.
+`))
+		rotateParamsF = flag.Bool("rotate-params", false, strings.TrimSpace(`
+Measure every query with several "-- params:" comments twice, once as "name
+(single params)" using only the first params, and once as "name (rotated
+params)" rotating through all of them on successive executions. The former is
+the best case of a plan tailored to a single value, the latter the average
+case across the parameter space. Requires -m prepare, or -m explain with
+-generic-plan.
+`))
+		pgbouncerF = flag.Bool("pgbouncer", false, strings.TrimSpace(`
+Compatibility mode for connection poolers such as PgBouncer in transaction
+pooling mode. Uses the simple query protocol instead of prepared statements,
+which means that -m client always includes the planning time.
+`))
+		connectTimeoutF = flag.Duration("connect-timeout", 0, "Timeout for establishing the database connection, e.g. 5s. 0 means no timeout.")
+		keepaliveF      = flag.Duration("keepalive", 0, strings.TrimSpace(`
+Interval for TCP keepalive probes on the database connection, e.g. 30s. Keeps
+idle connections from being dropped by firewalls or load balancers with
+aggressive idle timeouts during long runs. 0 uses the default of 5m.
+`))
+		queryTimeoutF = flag.Duration("query-timeout", 0, strings.TrimSpace(`
+Timeout for each individual query execution, e.g. 10s. 0 means no timeout. A
+query exceeding the timeout is treated as failed, see -quiet-errors. The
+connection is replaced after a timeout, restoring the session settings and
+prepared statements, but init.sql is not executed again, so it shouldn't create
+session state such as temp tables.
+`))
+		maxQueryDurationF = flag.Duration("max-query-duration", 0, strings.TrimSpace(`
+Cap the duration of each individual query execution, e.g. 5s. Unlike
+-query-timeout, an execution exceeding the cap is discarded without failing
+the query, so pathologically slow queries don't blow the time budget of the
+benchmark. The number of capped executions is shown in the "capped" stat.
+`))
+		genericPlanF = flag.Bool("generic-plan", false, strings.TrimSpace(`
+Measure the generic plan of prepared statements instead of the custom plan by
+setting plan_cache_mode = force_generic_plan (PostgreSQL 12+). For -m explain
+this explains the EXECUTE of an explicitly prepared statement, since EXPLAIN
+(GENERIC_PLAN) can't be combined with ANALYZE. Can't be combined with -p or
+-pgbouncer.
+`))
+		timerOverheadF = flag.Bool("timer-overhead", false, strings.TrimSpace(`
+Subtract the overhead of reading the clock from the -m client and -m multi
+measurements. The overhead is calibrated once at startup. This improves the
+accuracy for extremely fast queries.
+`))
+		seqScanRowsF = flag.Float64("seq-scan-rows", 0, strings.TrimSpace(`
+Report sequential scans reading at least the given number of rows that weren't
+in the first plan of the query, e.g. after a statistics change made the
+planner abandon an index. Requires -m explain. 0 disables the check.
+`))
+		seqScanFailF     = flag.Bool("seq-scan-fail", false, "Abort the benchmark when a sequential scan is reported by -seq-scan-rows.")
+		roundRobinConnsF = flag.Int("round-robin-conns", 1, strings.TrimSpace(`
+Number of connections to open upfront. Each iteration uses the next connection
+in turn, which models the plan cache warmth of an application using a
+connection pool. Queries are still executed sequentially. init.sql and
+destroy.sql are executed on the first connection only.
+`))
+		freshConnF = flag.Bool("fresh-conn", false, strings.TrimSpace(`
+Execute every query on a new database connection, so connection-local state
+such as session settings or prepared statements can't leak between queries.
+The time for connecting is not included in the measurements. init.sql is only
+executed on the initial connection, so session state it creates such as temp
+tables isn't available to the queries.
+`))
+		coldF = flag.Bool("cold", false, strings.TrimSpace(`
+Execute DISCARD ALL before every iteration, so no session state such as
+prepared statements or cached plans is reused between iterations. Temporary
+tables created by init.sql are discarded as well.
+`))
+		coldCmdF = flag.String("cold-cmd", "", strings.TrimSpace(`
+Shell command executed before every iteration, e.g. to flush the OS page cache
+or restart PostgreSQL for measuring cold reads. The database connection is
+reestablished after the command.
+`))
+		isolationF = flag.String("isolation", "", strings.TrimSpace(`
+Transaction isolation level of the measured statements, one of "read
+committed", "repeatable read" or "serializable". Applied after init.sql via
+default_transaction_isolation, so it also applies to -in-transaction and
+-tx-per-iteration. Serialization failures (SQLSTATE 40001) abort the benchmark
+unless -keep-going is given, which reports them as part of the error rate.
+`))
+		execCmdF = flag.String("exec-cmd", "", strings.TrimSpace(`
+Shell command that -m exec runs for every execution of a query. It receives the
+SQL on stdin and has to print the measured duration in seconds on stdout, e.g.
+"0.0042". Allows measuring methods sqlbench doesn't support itself. A
+PostgreSQL connection via -c is still required, since sqlbench runs init.sql
+and destroy.sql and records the server version as usual.
+`))
+		batchSizeF     = flag.Int("batch-size", 100, "Number of executions that -m batch measures at once.")
+		inTransactionF = flag.Bool("in-transaction", false, strings.TrimSpace(`
+Execute all iterations inside a single transaction on every connection, which
+is committed after the benchmark. Models workloads that run many statements
+inside one transaction, e.g. sharing its snapshot and locks.
+`))
+		txPerIterationF = flag.Bool("tx-per-iteration", false, strings.TrimSpace(`
+Execute every iteration inside its own transaction, which is committed at the
+end of the iteration.
+`))
+		verifyF = flag.Bool("verify", false, strings.TrimSpace(`
+Execute every query once before the benchmark and abort if they don't all
+return the same rows, ignoring their order. Guards against rewrites of a query
+that are faster because they return wrong results. The queries should be free
+of side effects.
+`))
+		backendStatsF = flag.Duration("backend-stats", 0, strings.TrimSpace(`
+Sample the CPU time and memory usage of the PostgreSQL backend process while
+executing each query at the given interval, e.g. 10ms. The stats are read from
+/proc, so PostgreSQL must run on the same Linux host and be readable by the
+current user. Queries faster than the interval may have no memory samples.
+`))
+		pairedF = flag.Bool("paired", false, strings.TrimSpace(`
+Compare exactly two queries using paired samples. Both queries are executed
+back-to-back in every iteration, and the mean difference of their durations is
+reported with its 95% confidence interval and the p-value of a paired t-test.
+This cancels out noise shared by both queries, e.g. from other workloads.
+`))
+		countOnlyF = flag.Bool("count-only", false, strings.TrimSpace(`
+Wrap every query as SELECT count(*) FROM (query), so only a single row is
+transferred to the client. Isolates the execution time of -m client from the
+time for transferring and decoding the result. Only works for queries that can
+be used as a subquery, e.g. no INSERT without RETURNING.
+`))
+		rateF = flag.Float64("rate", 0, strings.TrimSpace(`
+Issue queries at the given rate per second on a fixed schedule instead of
+executing the next query as soon as the previous one finished. Queries that
+can't be issued on time because the previous one is still running are queued,
+and the queueing delay is displayed separately from the measured durations.
+The corrected percentiles include the queueing delay, which avoids the
+coordinated omission of slow queries delaying all subsequent ones. Models the
+latency under a given load rather than at saturation.
+`))
+		formatF = flag.String("f", formatTable, strings.TrimSpace(`
+Output format. One of: `+strings.Join(outputFormats, ", ")+`. "benchstat" prints
+every sample as a line of the Go benchmark format once the benchmark finished,
+e.g. "BenchmarkQueryName 1 1234567 ns/op", for comparing runs with benchstat.
+`))
+		keepaliveQueryF = flag.String("keepalive-query", "", strings.TrimSpace(`
+Query that is executed on the connection of the next query while -rate waits
+for its scheduled time, e.g. "SELECT 1". Keeps long gaps between executions
+from letting the connection go idle, so the paced measurements stay comparable
+to back-to-back ones. Requires -rate.
+`))
+		keepaliveIntervalF = flag.Duration("keepalive-interval", time.Second, "Interval between -keepalive-query executions.")
+		silentF            = flag.Bool("s", false, "Silent mode for non-interactive use, only prints stats once after terminating.")
+		quietF             = flag.Bool("q", false, strings.TrimSpace(`
+Quiet mode for scripting. Doesn't print anything to stdout, so only the exit
+code indicates whether the benchmark completed, e.g. without -seq-scan-fail
+aborting it. Errors and warnings are still printed to stderr. Implies -s.
+`))
+		quietErrorsF = flag.Bool("quiet-errors", false, strings.TrimSpace(`
+Continue benchmarking when a query fails by dropping the failed query from the
+benchmark. The failed queries and their errors are listed at the end.
+`))
+		keepGoingF = flag.Bool("keep-going", false, strings.TrimSpace(`
+Continue benchmarking when a query fails, and keep executing the failed query
+in later iterations. Each query's number of successful and failed executions,
+its error rate, its failures by SQLSTATE and its most recent error are listed
+at the end. Useful for debugging flaky environments, or for workloads where the
+error rate under contention, e.g. due to deadlocks, is a metric of its own.
+`))
+		failOnErrorRateF = flag.Float64("fail-on-error-rate", -1, strings.TrimSpace(`
+Exit with an error after the benchmark if the error rate of any query exceeds
+the given percentage, e.g. 1 for 1%. Turns a -keep-going load test into a
+pass/fail gate, e.g. for catching lock contention in CI. Requires -keep-going.
+`))
+		maxNegativeRetriesF = flag.Int("max-negative-retries", 100, strings.TrimSpace(`
+Maximum number of consecutive retries of an execution for which EXPLAIN
+reported a negative time, which happens with buggy clocks such as Docker for
+Mac's. The benchmark is aborted with the error once it's exceeded. 0 retries
+forever, but prints a warning every 100 consecutive retries.
+`))
+		maxReconnectsF = flag.Int("max-reconnects", 0, strings.TrimSpace(`
+Reconnect when the connection is lost mid-run, e.g. due to a failover, instead
+of aborting the benchmark. The execution that lost the connection is dropped,
+the session settings and prepared statements are restored, and the benchmark
+continues. Gives up after the given number of reconnects. init.sql is not
+executed again, so it shouldn't create session state such as temp tables.
+`))
+		maxNameWidthF = flag.Int("max-name-width", 0, strings.TrimSpace(`
+Truncate query names longer than the given number of characters. By default
+names are only truncated if the table doesn't fit the terminal width, which is
+taken from the COLUMNS environment variable or the terminal itself.
+`))
+		matrixF = flag.Bool("matrix", false, strings.TrimSpace(`
+Display a matrix of the mean ratios between every pair of queries below the
+table. Useful for choosing among several alternative queries.
+`))
+		layoutF = flag.String("layout", layoutAuto, strings.TrimSpace(`
+Table layout. One of: `+tableLayoutNames()+`. "columns" shows queries as
+columns and stats as rows, "rows" is the transposed layout which scales better
+to many queries. "auto" switches to "rows" when "columns" doesn't fit the
+terminal.
+`))
+		unitF = flag.String("unit", "ms", strings.TrimSpace(`
+Unit for displaying durations. One of: `+timeUnitNames()+`. "auto" picks the
+unit that fits the mean of the fastest query, e.g. "us" for sub-millisecond
+queries.
+`))
+		reportReplicaLagF = flag.Bool("report-replica-lag", false, strings.TrimSpace(`
+Report whether every database is a replica, and its replication lag at the
+start and end of the benchmark, based on the commit time of the last replayed
+transaction. Helps with judging whether results taken against a replica are
+comparable to the primary. Requires -v.
+`))
+		warmupUntilStableF = flag.Bool("warmup-until-stable", false, strings.TrimSpace(`
+Execute every query before the benchmark until its durations are stable, i.e.
+the mean of the last -warmup-window executions is within -warmup-tolerance of
+the mean of the window before. These warmup executions aren't recorded, which
+leaves steady-state stats for queries with very different warmup curves. Gives
+up after 100 windows.
+`))
+		warmupWindowF    = flag.Int("warmup-window", 10, "Number of executions per window for -warmup-until-stable.")
+		warmupToleranceF = flag.Float64("warmup-tolerance", 0.05, "Maximum relative difference between the means of the last two windows for -warmup-until-stable, e.g. 0.05 for 5%.")
+		textPlansF       = flag.Bool("text-plans", false, strings.TrimSpace(`
+Execute every query once more at the end of the benchmark via EXPLAIN
+(ANALYZE) and print its plan in the human readable TEXT format along with the
+statements under -v. Note that this executes data modifying queries once more
+as well. Requires -v.
 `))
-		silentF  = flag.Bool("s", false, "Silent mode for non-interactive use, only prints stats once after terminating.")
 		versionF = flag.Bool("version", false, "Print version and exit.")
 		verboseF = flag.Bool("v", false, strings.TrimSpace(`
-Verbose output. Print the content of all SQL queries, as well as the
-PostgreSQL version.
+Verbose output. Print the statements executed for all SQL queries, as well as
+the PostgreSQL version.
 `))
 	)
 	flag.Parse()
@@ -66,263 +516,1974 @@ PostgreSQL version.
 		return nil
 	}
 
-	methodFn, ok := queryDurationFuncs[*methodF]
-	if !ok {
-		return fmt.Errorf("-m: unknown method: %q: must be one of %s", *methodF, queryDurationMethods())
+	if *quietF {
+		if *verboseF {
+			return errors.New("-q: can't be combined with -v")
+		}
+		*silentF = true
 	}
 
-	bench, err := LoadBenchmark(flag.Args()...)
-	if err != nil {
-		return err
+	if *warmupUntilStableF {
+		if *warmupWindowF < 1 {
+			return fmt.Errorf("-warmup-window: must be >= 1, got %d", *warmupWindowF)
+		} else if *warmupToleranceF <= 0 {
+			return fmt.Errorf("-warmup-tolerance: must be > 0, got %g", *warmupToleranceF)
+		} else if *coldF || *coldCmdF != "" {
+			return errors.New("-warmup-until-stable: can't be combined with -cold or -cold-cmd")
+		}
 	}
 
-	db, err := sql.Open("pgx", *connF)
-	if err != nil {
-		return err
+	if *textPlansF && !*verboseF {
+		return errors.New("-text-plans: requires -v")
 	}
 
-	ctx := context.TODO()
-	conn, err := db.Conn(ctx)
+	if *reportReplicaLagF && !*verboseF {
+		return errors.New("-report-replica-lag: requires -v")
+	}
+
+	if !contains(outputFormats, *formatF) {
+		return fmt.Errorf("-f: unknown format: %q: must be one of %s", *formatF, strings.Join(outputFormats, ", "))
+	} else if *formatF == formatBenchstat {
+		// The table refreshes would end up in the benchstat input.
+		*silentF = true
+	}
+
+	percentiles, err := parsePercentiles(*percentilesF)
 	if err != nil {
-		return err
+		return fmt.Errorf("-percentiles: %w", err)
+	}
+	setPercentiles(percentiles)
+
+	if *trimPercentF < 0 || *trimPercentF >= 50 {
+		return fmt.Errorf("-trim-percent: must be >= 0 and < 50, got %g", *trimPercentF)
+	}
+	trimPercent = *trimPercentF
+
+	if *histogramAfterF < 0 {
+		return fmt.Errorf("-histogram-after: must be >= 0, got %d", *histogramAfterF)
+	} else if *histogramAfterF > 0 && (*pairedF || *rateF > 0 || *rawOutF != "" || *formatF == formatBenchstat) {
+		return errors.New("-histogram-after: can't be combined with -paired, -rate, -raw-out or -f benchstat")
+	}
+	histogramAfter = *histogramAfterF
+
+	if !contains(tableLayouts, *layoutF) {
+		return fmt.Errorf("-layout: unknown layout: %q: must be one of %s", *layoutF, tableLayoutNames())
+	}
+	if _, ok := timeUnits[*unitF]; !ok && *unitF != unitAuto {
+		return fmt.Errorf("-unit: unknown unit: %q: must be one of %s", *unitF, timeUnitNames())
+	}
+
+	if *histBucketsF < 1 {
+		return fmt.Errorf("-hist-buckets: must be >= 1, got %d", *histBucketsF)
+	}
+
+	if *genericPlanF && (*planF || *pgbouncerF) {
+		return errors.New("-generic-plan: requires prepared statements and can't be combined with -p or -pgbouncer")
 	}
 
-	if err := execIndividually(ctx, conn, bench.Init); err != nil {
+	if *seqScanRowsF < 0 {
+		return fmt.Errorf("-seq-scan-rows: must be >= 0, got %g", *seqScanRowsF)
+	} else if *seqScanRowsF > 0 && *methodF != "explain" {
+		return errors.New("-seq-scan-rows: requires -m explain")
+	} else if *seqScanFailF && *seqScanRowsF == 0 {
+		return errors.New("-seq-scan-fail: requires -seq-scan-rows")
+	}
+
+	if *maxQueryDurationF < 0 {
+		return fmt.Errorf("-max-query-duration: must be >= 0, got %s", *maxQueryDurationF)
+	} else if *maxQueryDurationF > 0 && *queryTimeoutF > 0 {
+		return errors.New("-max-query-duration: can't be combined with -query-timeout")
+	}
+
+	if err := validateTransactionFlags(*inTransactionF, *txPerIterationF, map[string]bool{
+		"-fresh-conn":         *freshConnF,
+		"-cold":               *coldF,
+		"-cold-cmd":           *coldCmdF != "",
+		"-query-timeout":      *queryTimeoutF > 0,
+		"-max-query-duration": *maxQueryDurationF > 0,
+		"-quiet-errors":       *quietErrorsF,
+		"-keep-going":         *keepGoingF,
+		"-max-reconnects":     *maxReconnectsF > 0,
+	}); err != nil {
 		return err
 	}
 
-	drawTicker := &time.Ticker{}
-	if *silentF == false {
-		drawTicker = time.NewTicker(time.Second / 10)
-		defer drawTicker.Stop()
+	if *methodF == "exec" && *execCmdF == "" {
+		return errors.New("-m exec: requires -exec-cmd")
+	} else if *methodF != "exec" && *execCmdF != "" {
+		return errors.New("-exec-cmd: requires -m exec")
 	}
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
+	if *batchSizeF < 1 {
+		return fmt.Errorf("-batch-size: must be >= 1, got %d", *batchSizeF)
+	}
 
-	var secondsTimer = &time.Timer{}
-	secondsD := time.Duration(float64(time.Second) * *secondsF)
-	if secondsD > 0 {
-		secondsTimer = time.NewTimer(secondsD)
-		defer secondsTimer.Stop()
+	if *countOnlyF && *methodF == "multi" {
+		return errors.New("-count-only: can't be combined with -m multi")
+	} else if *countOnlyF && *verifyF {
+		// -verify would only compare the counts rather than the results.
+		return errors.New("-count-only: can't be combined with -verify")
 	}
 
-	var baseline []*Query
-	if *inCsvF != "" {
-		baseline, err = loadBaseline(*inCsvF)
-		if err != nil {
-			return err
+	if *verifyF && *methodF == "multi" {
+		return errors.New("-verify: can't be combined with -m multi")
+	}
+
+	if len(workloadF) > 0 {
+		if *countOnlyF || *verifyF || *rotateParamsF {
+			return errors.New("-workload: can't be combined with -count-only, -verify or -rotate-params")
 		}
 	}
 
-	var csvW *csv.Writer
-	if *outCsvF != "" {
-		csvFile, err := os.OpenFile(*outCsvF, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
-		if err != nil {
-			return err
+	if *backendStatsF < 0 {
+		return fmt.Errorf("-backend-stats: must be >= 0, got %s", *backendStatsF)
+	} else if *backendStatsF > 0 && *pgbouncerF {
+		return errors.New("-backend-stats: can't be combined with -pgbouncer")
+	}
+
+	if *keepGoingF && *quietErrorsF {
+		return errors.New("-keep-going: can't be combined with -quiet-errors")
+	}
+
+	labels, err := parseLabels(labelF)
+	if err != nil {
+		return fmt.Errorf("-label: %w", err)
+	}
+
+	if *resultsDBF != "" {
+		if err := checkResultsDB(); err != nil {
+			return fmt.Errorf("-results-db: %w", err)
 		}
-		defer csvFile.Close()
-		csvW = csv.NewWriter(csvFile)
-		if err := csvW.Write(csvHeader()); err != nil {
-			return err
+	} else if *gitSHAF != "" {
+		return errors.New("-git-sha: requires -results-db")
+	}
+
+	if *keepaliveQueryF != "" {
+		if *rateF <= 0 {
+			return errors.New("-keepalive-query: requires -rate")
+		} else if *keepaliveIntervalF <= 0 {
+			return fmt.Errorf("-keepalive-interval: must be > 0, got %s", *keepaliveIntervalF)
 		}
-		defer csvW.Flush()
 	}
 
-	var exitMsg string
+	if *failOnErrorRateF >= 0 && !*keepGoingF {
+		return errors.New("-fail-on-error-rate: requires -keep-going")
+	} else if *failOnErrorRateF > 100 {
+		return fmt.Errorf("-fail-on-error-rate: must be <= 100, got %g", *failOnErrorRateF)
+	}
 
-	preparedFns := map[string]func() (time.Duration, error){}
+	if *maxNegativeRetriesF < 0 {
+		return fmt.Errorf("-max-negative-retries: must be >= 0, got %d", *maxNegativeRetriesF)
+	}
 
-outerLoop:
-	for i := int64(1); ; i++ {
-		for _, query := range bench.Queries {
-			preparedFn := preparedFns[query.Path]
-			if preparedFn == nil {
-				preparedFn = methodFn(ctx, conn, query.SQL, *planF)
-				preparedFns[query.Path] = preparedFn
-			}
+	if *maxReconnectsF < 0 {
+		return fmt.Errorf("-max-reconnects: must be >= 0, got %d", *maxReconnectsF)
+	} else if *maxReconnectsF > 0 && *freshConnF {
+		return errors.New("-max-reconnects: can't be combined with -fresh-conn")
+	}
 
-			for {
-				delta, err := preparedFn()
-				if errors.As(err, &negativeTimeError{}) {
-					query.Errors++
-					continue
-				} else if err != nil {
-					return fmt.Errorf("%s: %w", query.Path, err)
-				}
-				seconds := delta.Seconds()
-				query.Seconds = append(query.Seconds, seconds)
-				if csvW != nil {
-					row := &CSVRow{
-						Iteration: i,
-						Query:     query.Name,
-						Seconds:   seconds,
-					}
-					if record, err := row.MarshalRecord(); err != nil {
-						return err
-					} else if err := csvW.Write(record); err != nil {
-						return err
-					}
-				}
-				break
-			}
+	if *rateF < 0 {
+		return fmt.Errorf("-rate: must be >= 0, got %g", *rateF)
+	}
+
+	if *minSamplesF < 0 {
+		return fmt.Errorf("-min-samples: must be >= 0, got %d", *minSamplesF)
+	}
+
+	if *plansOutF != "" && *methodF != "explain" {
+		return errors.New("-plans-out: requires -m explain")
+	} else if *planDiffF != "" && *methodF != "explain" {
+		return errors.New("-plan-diff: requires -m explain")
+	} else if *explainVerboseF && *methodF != "explain" {
+		return errors.New("-explain-verbose: requires -m explain")
+	}
+	var baselinePlans map[string]*explainPlan
+	if *planDiffF != "" {
+		if baselinePlans, err = readPlans(*planDiffF); err != nil {
+			return fmt.Errorf("-plan-diff: %w", err)
 		}
+	}
 
-		if i >= *iterationsF && *iterationsF > 0 {
-			exitMsg = fmt.Sprintf("Stopping after %d iterations as requested.", i)
-			break
+	if *roundRobinConnsF < 1 {
+		return fmt.Errorf("-round-robin-conns: must be >= 1, got %d", *roundRobinConnsF)
+	}
+
+	if *buffersSummaryF && *methodF != "explain" {
+		return errors.New("-explain-buffers-summary: requires -m explain")
+	}
+
+	if *keepaliveF < 0 {
+		return fmt.Errorf("-keepalive: must be >= 0, got %s", *keepaliveF)
+	}
+
+	if *targetRSEF < 0 {
+		return fmt.Errorf("-target-rse: must be >= 0, got %g", *targetRSEF)
+	} else if *targetRSEMinF < 2 {
+		return fmt.Errorf("-target-rse-min: must be >= 2, got %d", *targetRSEMinF)
+	}
+
+	// sessionSetup holds statements that are executed on every new connection.
+	var sessionSetup []string
+	if *genericPlanF {
+		sessionSetup = append(sessionSetup, "SET plan_cache_mode = force_generic_plan")
+	}
+
+	var setStmts []string
+	for _, setting := range setF {
+		stmt, err := setStatement(setting)
+		if err != nil {
+			return fmt.Errorf("-set: %w", err)
 		}
-		select {
-		case <-drawTicker.C:
-			if err := bench.Update(); err != nil {
-				return err
-			} else if err := render(bench.Queries, *silentF == false, baseline); err != nil {
-				return err
-			}
-		case sig := <-sigCh:
-			exitMsg = fmt.Sprintf("Stopping due to receiving %s signal.", sig)
-			break outerLoop
-		case <-secondsTimer.C:
-			exitMsg = fmt.Sprintf("Stopping after %s as requested.", secondsD)
-			break outerLoop
-		default:
+		setStmts = append(setStmts, stmt)
+	}
+	if *isolationF != "" {
+		level, err := isolationLevel(*isolationF)
+		if err != nil {
+			return fmt.Errorf("-isolation: %w", err)
 		}
+		stmt, _ := setStatement("default_transaction_isolation=" + level)
+		setStmts = append(setStmts, stmt)
 	}
 
-	if err := bench.Update(); err != nil {
+	methodFn, ok := queryDurationFuncs[*methodF]
+	if !ok {
+		return fmt.Errorf("-m: unknown method: %q: must be one of %s", *methodF, queryDurationMethods())
+	}
+	if *explainOnceF {
+		if *methodF != "client" {
+			return errors.New("-explain-once: requires -m client")
+		}
+		methodFn = explainOnceDuration
+	}
+
+	if *connFileF != "" {
+		var connSet bool
+		flag.Visit(func(f *flag.Flag) { connSet = connSet || f.Name == "c" })
+		if connSet {
+			return errors.New("-conn-file: can't be combined with -c")
+		}
+		connString, err := readConnFile(*connFileF)
+		if err != nil {
+			return fmt.Errorf("-conn-file: %w", err)
+		}
+		*connF = connString
+	}
+
+	// connStrings holds the databases to benchmark, see -db.
+	connStrings := []string{*connF}
+	if len(dbF) > 0 {
+		var conflicting bool
+		flag.Visit(func(f *flag.Flag) { conflicting = conflicting || f.Name == "c" || f.Name == "conn-file" })
+		if conflicting {
+			return errors.New("-db: can't be combined with -c or -conn-file")
+		} else if len(dbF) > 1 && *roundRobinConnsF > 1 {
+			return errors.New("-db: can't be given multiple times with -round-robin-conns")
+		} else if len(dbF) > 1 && *verifyF {
+			return errors.New("-db: can't be given multiple times with -verify")
+		}
+		connStrings = dbF
+	}
+
+	var bench *Benchmark
+	if *initF != "" || *destroyF != "" {
+		bench, err = LoadBenchmarkExplicit(*initF, *destroyF, flag.Args()...)
+	} else {
+		bench, err = LoadBenchmark(flag.Args()...)
+	}
+	if err != nil {
 		return err
-	} else if err := render(bench.Queries, *silentF == false, baseline); err != nil {
+	}
+	if *nameFromCommentF {
+		if err := nameFromComments(bench.Queries); err != nil {
+			return fmt.Errorf("-name-from-comment: %w", err)
+		}
+	}
+	for _, spec := range workloadF {
+		name, paths, err := parseWorkload(spec)
+		if err != nil {
+			return fmt.Errorf("-workload: %w", err)
+		}
+		workload, err := loadWorkload(name, paths...)
+		if err != nil {
+			return fmt.Errorf("-workload: %w", err)
+		}
+		bench.Queries = append(bench.Queries, workload)
+	}
+	if err := bench.Filter(splitList(*onlyF), splitList(*excludeF)); err != nil {
 		return err
 	}
-	fmt.Printf("\n%s\n", exitMsg)
 
-	if err := execIndividually(ctx, conn, bench.Destroy); err != nil {
-		return err
+	seed := *seedF
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for _, query := range bench.Queries {
+		if err := checkCallStatements(query, *methodF, *clientVsServerF, *explainOnceF, *countOnlyF); err != nil {
+			return fmt.Errorf("%s: %w", query.Path, err)
+		}
+		if *methodF == "batch" && len(queryDirectives(query.SQL, "params")) > 0 {
+			return fmt.Errorf("%s: -m batch doesn't support \"-- params:\" comments", query.Path)
+		} else if *methodF == "batch" && !batchable(query.SQL) {
+			return fmt.Errorf("%s: -m batch doesn't support RETURNING clauses or WITH queries modifying data", query.Path)
+		}
+		if *countOnlyF {
+			// The hash must change as well, so the baseline check notices
+			// comparisons with runs that didn't use -count-only.
+			query.SQL = countOnlySQL(query.SQL)
+			query.SQLHash = sqlHash(query.SQL)
+		}
+		if !isQueryTemplate(query.SQL) {
+			continue
+		} else if len(query.Steps) > 0 {
+			return fmt.Errorf("-workload: %s: templates aren't supported", query.Name)
+		} else if query.Template, err = parseQueryTemplate(query.Name, query.SQL, rng); err != nil {
+			return fmt.Errorf("%s: %w", query.Path, err)
+		}
 	}
 
-	if *verboseF {
-		var version string
-		if err := db.QueryRow("SELECT version();").Scan(&version); err != nil {
-			return fmt.Errorf("failed to determine PostgreSQL version: %w", err)
+	if *preparedVsUnpreparedF {
+		if *planF || *pgbouncerF || *methodF == "multi" {
+			return errors.New("-prepared-vs-unprepared: can't be combined with -p, -pgbouncer or -m multi")
+		}
+		bench.Queries = splitPrepared(bench.Queries)
+	}
+	if len(dbF) > 0 {
+		bench.Queries = splitDatabases(bench.Queries, databaseLabels(dbF))
+	}
+	var clientServerPairs [][2]*Query
+	if *clientVsServerF {
+		if *methodF != "explain" {
+			return errors.New("-client-vs-server: requires -m explain")
+		} else if *preparedVsUnpreparedF || *rotateParamsF {
+			return errors.New("-client-vs-server: can't be combined with -prepared-vs-unprepared or -rotate-params")
+		}
+		bench.Queries, clientServerPairs = splitClientServer(bench.Queries)
+	}
+	var rotatedPairs [][2]*Query
+	if *rotateParamsF {
+		if *methodF != "prepare" && !(*methodF == "explain" && *genericPlanF) {
+			return errors.New("-rotate-params: requires -m prepare, or -m explain with -generic-plan")
 		}
+		bench.Queries, rotatedPairs = splitRotatedParams(bench.Queries)
+		if len(rotatedPairs) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: -rotate-params: no query has several \"-- params:\" comments\n")
+		}
+	}
 
-		args := strings.Join(os.Args[1:], " ")
-		fmt.Printf("\n")
-		fmt.Printf("postgres version: %s\n", version)
-		fmt.Printf("sqlbench %s\n\n", args)
-		all := append(append([]*Query{bench.Init}, bench.Queries...), bench.Destroy)
-		for _, q := range all {
-			if q != nil {
-				fmt.Printf("==> %s <==\n%s\n", q.Path, q.SQL)
+	// pairs holds the durations of both queries for every iteration in which
+	// both were executed successfully, see -paired.
+	var (
+		pairQueries [2]*Query
+		pairs       [2][]float64
+	)
+	if *pairedF {
+		if len(bench.Queries) != 2 {
+			return fmt.Errorf("-paired: requires exactly 2 queries, got %d", len(bench.Queries))
+		}
+		for i, q := range bench.Queries {
+			if q.Weight != 1 {
+				return fmt.Errorf("-paired: %s: weight must be 1, got %d", q.Name, q.Weight)
 			}
+			pairQueries[i] = q
 		}
 	}
 
-	return nil
+	var (
+		baseline         []*Query
+		baselineMetadata map[string]string
+	)
+	if *inCsvF != "" {
+		baseline, baselineMetadata, err = loadBaseline(*inCsvF)
+		if err != nil {
+			return err
+		}
+	}
+	if *compareF == "" {
+		warnMetadataDiff(baselineMetadata, map[string]string{
+			"method":   *methodF,
+			"planning": strconv.FormatBool(*planF),
+		})
+	}
+
+	for _, query := range bench.Queries {
+		if b := findQuery(baseline, query.Name); b != nil && b.SQLHash != "" && b.SQLHash != query.SQLHash {
+			fmt.Fprintf(os.Stderr, "Warning: the SQL of %s differs from the baseline: sql hash %s != %s\n", query.Name, query.SQLHash, b.SQLHash)
+		}
+	}
+	if len(baseline) > 0 && *compareF == "" {
+		warnBaselineDiff(bench.Queries, baseline)
+	}
+
+	if *baselineQueryF != "" {
+		candidates := bench.Queries
+		if len(baseline) > 0 {
+			candidates = baseline
+		}
+		if findQuery(candidates, *baselineQueryF) == nil {
+			return fmt.Errorf("-baseline-query: unknown query: %q", *baselineQueryF)
+		}
+	}
+
+	outColumns, err := parseCSVColumns(*csvColumnsF)
+	if err != nil {
+		return fmt.Errorf("-csv-columns: %w", err)
+	}
+
+	sortStats, err := parseTableStats(*sortF)
+	if err != nil {
+		return fmt.Errorf("-sort: %w", err)
+	} else if len(sortStats) != 1 {
+		return fmt.Errorf("-sort: must name a single stat, got %q", *sortF)
+	}
+	bench.SortBy = sortStats[0].Value
+
+	if *orderFileF != "" {
+		if bench.Order, err = readOrderFile(*orderFileF); err != nil {
+			return fmt.Errorf("-order-file: %w", err)
+		}
+	}
+
+	if *baselineLabelF != "" && *inCsvF == "" {
+		return errors.New("-baseline-label: requires a baseline via -i")
+	} else if *currentLabelF != "" && *inCsvF == "" {
+		return errors.New("-current-label: requires a baseline via -i")
+	}
+
+	var compareStat *tableStat
+	if *compareStatF != "" {
+		compareStats, err := parseTableStats(*compareStatF)
+		if err != nil {
+			return fmt.Errorf("-compare-stat: %w", err)
+		} else if len(compareStats) != 1 {
+			return fmt.Errorf("-compare-stat: must name a single stat, got %q", *compareStatF)
+		}
+		compareStat = &compareStats[0]
+	}
+
+	var stats []tableStat
+	if *statsF != "" {
+		if stats, err = parseTableStats(*statsF); err != nil {
+			return fmt.Errorf("-stats: %w", err)
+		}
+	}
+
+	renderOpts := renderOptions{
+		Clear:         *silentF == false,
+		Baseline:      baseline,
+		MaxNameWidth:  *maxNameWidthF,
+		Layout:        *layoutF,
+		BaselineQuery: *baselineQueryF,
+		Stats:         stats,
+		Matrix:        *matrixF,
+		AbsDelta:      *absDeltaF,
+		Unit:          *unitF,
+		CompareStat:   compareStat,
+		BaselineLabel: *baselineLabelF,
+		CurrentLabel:  *currentLabelF,
+	}
+
+	if *compareF != "" {
+		if *inCsvF == "" {
+			return errors.New("-compare: requires a baseline via -i")
+		} else if flag.NArg() > 0 {
+			return errors.New("-compare: can't be combined with query files")
+		}
+		current, currentMetadata, err := loadBaseline(*compareF)
+		if err != nil {
+			return err
+		}
+		warnMetadataDiff(baselineMetadata, currentMetadata)
+		warnBaselineDiff(current, baseline)
+		compareBench := &Benchmark{Queries: current, SortBy: bench.SortBy, Order: bench.Order}
+		if err := compareBench.Update(); err != nil {
+			return err
+		}
+		if *tableOutF != "" {
+			if err := writeTableCSVFile(*tableOutF, compareBench.Queries, renderOpts); err != nil {
+				return fmt.Errorf("-table-out: %w", err)
+			}
+		}
+		if *quietF {
+			return nil
+		} else if *formatF == formatBenchstat {
+			return writeBenchstat(os.Stdout, compareBench.Queries)
+		}
+		renderOpts.Clear = false
+		return render(compareBench.Queries, renderOpts)
+	}
+
+	dbs := make([]*sql.DB, len(connStrings))
+	for d, connString := range connStrings {
+		if dbs[d], err = openDB(connString, *pgbouncerF, *keepaliveF); err != nil {
+			return err
+		}
+		if *freshConnF || *coldCmdF != "" {
+			// Otherwise closed connections are returned to the pool and reused.
+			dbs[d].SetMaxIdleConns(0)
+		}
+	}
+
+	ctx := context.TODO()
+	setupSession := func(conn *sql.Conn) error {
+		for _, stmt := range sessionSetup {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("%s: %w", stmt, err)
+			}
+		}
+		return nil
+	}
+	// connect establishes a new connection for conns[c], which is to the c-th
+	// database if there are several.
+	connect := func(c int) (*sql.Conn, error) {
+		db := dbs[0]
+		if len(dbs) > 1 {
+			db = dbs[c]
+		}
+		connectCtx, cancel := ctx, context.CancelFunc(func() {})
+		if *connectTimeoutF > 0 {
+			connectCtx, cancel = context.WithTimeout(ctx, *connectTimeoutF)
+		}
+		defer cancel()
+		conn, err := db.Conn(connectCtx)
+		if err != nil && connectCtx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("connect timeout of %s exceeded: %w", *connectTimeoutF, err)
+		} else if err != nil {
+			return nil, err
+		}
+		if err := setupSession(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	// conns holds the connections the iterations rotate through, see
+	// -round-robin-conns, or one connection per database if there are
+	// several, see -db. dbConns holds one connection to each database.
+	numConns := *roundRobinConnsF
+	if len(dbs) > 1 {
+		numConns = len(dbs)
+	}
+	conns := make([]*sql.Conn, numConns)
+	for c := range conns {
+		if conns[c], err = connect(c); err != nil {
+			return err
+		}
+	}
+	conn, dbConns := conns[0], conns[:len(dbs)]
+
+	// replicaStart holds the replicaStatus of every database before running
+	// the benchmark, see -report-replica-lag.
+	var replicaStart []replicaStatus
+	if *reportReplicaLagF {
+		for _, db := range dbs {
+			status, err := queryReplicaStatus(ctx, db)
+			if err != nil {
+				return fmt.Errorf("-report-replica-lag: %w", err)
+			}
+			replicaStart = append(replicaStart, status)
+		}
+	}
+
+	for _, c := range dbConns {
+		if err := execIndividually(ctx, c, bench.Init); err != nil {
+			return err
+		}
+	}
+
+	// -set is applied after init.sql, so the settings don't affect the setup.
+	// Connections established later get them via sessionSetup.
+	for _, c := range conns {
+		for _, stmt := range setStmts {
+			if _, err := c.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("-set: %s: %w", stmt, err)
+			}
+		}
+	}
+	sessionSetup = append(sessionSetup, setStmts...)
+
+	if *analyzeFirstF || *analyzeTablesF != "" {
+		stmts := []string{"ANALYZE"}
+		if tables := splitList(*analyzeTablesF); len(tables) > 0 {
+			stmts = nil
+			for _, table := range tables {
+				stmts = append(stmts, "ANALYZE "+table)
+			}
+		}
+		for _, c := range dbConns {
+			for _, stmt := range stmts {
+				if _, err := c.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("-analyze-first: %s: %w", stmt, err)
+				}
+			}
+		}
+	}
+
+	if *verifyF {
+		if err := verifyResults(ctx, conn, bench.Queries); err != nil {
+			return err
+		}
+	}
+
+	// backendPIDs caches the backend process ids of connections for
+	// -backend-stats.
+	var (
+		backendPIDs = map[*sql.Conn]int{}
+		sampler     *backendSampler
+	)
+	backendPID := func(c *sql.Conn) (int, error) {
+		if pid, ok := backendPIDs[c]; ok {
+			return pid, nil
+		}
+		var pid int
+		if err := c.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+			return 0, fmt.Errorf("-backend-stats: %w", err)
+		}
+		backendPIDs[c] = pid
+		return pid, nil
+	}
+	if *backendStatsF > 0 {
+		pid, err := backendPID(conn)
+		if err != nil {
+			return err
+		} else if _, err := readBackendCPU(pid); err != nil {
+			return fmt.Errorf("-backend-stats: can't read the stats of backend process %d, PostgreSQL must run on the same host: %w", pid, err)
+		}
+		sampler = newBackendSampler(*backendStatsF)
+		defer sampler.Stop()
+	}
+
+	var liveW *liveWriter
+	if *liveOutF != "" {
+		if liveW, err = openLiveWriter(*liveOutF); err != nil {
+			return fmt.Errorf("-live-out: %w", err)
+		}
+		defer liveW.Close()
+	}
+
+	drawTicker := &time.Ticker{}
+	if *silentF == false || liveW != nil || *targetRSEF > 0 {
+		drawTicker = time.NewTicker(time.Second / 10)
+		defer drawTicker.Stop()
+	}
+
+	// interruptCtx is canceled when receiving a signal, and runCtx also once
+	// -t is reached. Unlike a plain channel, they allow the -rate pacing to be
+	// interrupted as well as the main loop.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	interruptCtx, interrupt := context.WithCancel(ctx)
+	defer interrupt()
+	var sig os.Signal
+	go func() {
+		select {
+		case sig = <-sigCh:
+			interrupt()
+		case <-interruptCtx.Done():
+		}
+	}()
+
+	runCtx, cancelRun := interruptCtx, context.CancelFunc(func() {})
+	secondsD := time.Duration(float64(time.Second) * *secondsF)
+	if secondsD > 0 {
+		runCtx, cancelRun = context.WithTimeout(interruptCtx, secondsD)
+	}
+	defer cancelRun()
+	// runDone is replaced by interruptCtx.Done() once -t was handled.
+	runDone := runCtx.Done()
+
+	var (
+		csvW    *csv.Writer
+		csvRows int
+	)
+	if *outCsvF != "" {
+		csvFile, err := os.OpenFile(*outCsvF, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		defer csvFile.Close()
+		var versions []string
+		for _, db := range dbs {
+			var version string
+			if err := db.QueryRow("SELECT version();").Scan(&version); err != nil {
+				return fmt.Errorf("failed to determine PostgreSQL version: %w", err)
+			}
+			versions = append(versions, version)
+		}
+		metadata := map[string]string{
+			"sqlbench version": version,
+			"postgres version": strings.Join(versions, "; "),
+			"method":           *methodF,
+			"planning":         strconv.FormatBool(*planF),
+			"started at":       time.Now().Format(time.RFC3339),
+			"args":             strings.Join(redactArgs(os.Args[1:]), " "),
+		}
+		if len(labels) > 0 {
+			metadata["labels"] = formatLabels(labels)
+		}
+		if err := writeCSVMetadata(csvFile, metadata); err != nil {
+			return err
+		}
+		csvW = csv.NewWriter(csvFile)
+		if err := csvW.Write(csvHeader(outColumns)); err != nil {
+			return err
+		}
+		defer csvW.Flush()
+	}
+
+	var jsonlW *jsonlWriter
+	if *jsonlOutF != "" {
+		if jsonlW, err = openJSONLWriter(*jsonlOutF, *flushEveryF, labels); err != nil {
+			return err
+		}
+		defer jsonlW.Close()
+	}
+
+	// finishMsg is set once -n or -t is reached, and becomes the exitMsg
+	// once all queries have -min-samples, or stalled.
+	var (
+		exitMsg   string
+		finishMsg string
+		skipped   []*Query
+		stalls    = &sampleStallTracker{max: minSamplesStallIterations}
+	)
+
+	// connPreparedFns holds the prepared query functions of each connection in
+	// conns.
+	connPreparedFns := make([]map[*Query]func(context.Context) (measurement, error), len(conns))
+	for c := range connPreparedFns {
+		connPreparedFns[c] = map[*Query]func(context.Context) (measurement, error){}
+	}
+	durationOpts := queryDurationOptions{
+		IncludePlanning: *planF,
+		SimpleProtocol:  *pgbouncerF,
+		GenericPlan:     *genericPlanF,
+		Buffers:         *buffersSummaryF,
+		Verbose:         *explainVerboseF,
+		BatchSize:       *batchSizeF,
+		ExecCmd:         *execCmdF,
+	}
+	if *timerOverheadF {
+		durationOpts.TimerOverhead = calibrateTimerOverhead()
+	}
+
+	if *inTransactionF {
+		for _, c := range conns {
+			if _, err := c.ExecContext(ctx, "BEGIN"); err != nil {
+				return fmt.Errorf("-in-transaction: %w", err)
+			}
+		}
+	}
+
+	if *warmupUntilStableF {
+		for _, query := range bench.Queries {
+			// The plan cache is per connection, so every connection the
+			// query is executed on needs to be warmed up.
+			var connIndexes []int
+			if len(dbs) > 1 {
+				connIndexes = []int{query.DB}
+			} else {
+				for c := range conns {
+					connIndexes = append(connIndexes, c)
+				}
+			}
+			for _, c := range connIndexes {
+				opts := durationOpts
+				opts.IncludePlanning = opts.IncludePlanning || query.IncludePlanning
+				opts.RotateParams = query.ParamSets > 0
+				preparedFn := connPreparedFns[c][query]
+				if preparedFn == nil && query.Template == nil {
+					preparedFn = query.durationFunc(methodFn)(ctx, conns[c], query.SQL, opts)
+					connPreparedFns[c][query] = preparedFn
+				}
+				detector := &warmupDetector{window: *warmupWindowF, tolerance: *warmupToleranceF}
+				var negativeRetries int
+				for !detector.Done() {
+					fn := preparedFn
+					if query.Template != nil {
+						rendered, err := renderQueryTemplate(query.Template)
+						if err != nil {
+							return fmt.Errorf("%s: %w", query.Path, err)
+						}
+						opts.Transient = true
+						fn = query.durationFunc(methodFn)(ctx, conns[c], rendered, opts)
+					}
+					m, err := fn(ctx)
+					if errors.As(err, &negativeTimeError{}) {
+						negativeRetries++
+						if err := retryNegativeTime(os.Stderr, query.Name, err, negativeRetries, *maxNegativeRetriesF); err != nil {
+							return fmt.Errorf("-warmup-until-stable: %s: %w", query.Path, err)
+						}
+						continue
+					} else if err != nil {
+						return fmt.Errorf("-warmup-until-stable: %s: %w", query.Path, pgbouncerHint(err))
+					}
+					negativeRetries = 0
+					detector.Add(m.Duration.Seconds())
+				}
+				query.WarmupExecutions += detector.N()
+				if !detector.Stable() {
+					fmt.Fprintf(os.Stderr, "Warning: -warmup-until-stable: %s didn't stabilize within %d executions\n", query.Name, detector.N())
+				}
+			}
+		}
+	}
+
+	runTime := runTimes{Start: time.Now()}
+	// issued is the number of queries issued so far, see -rate.
+	var issued int64
+	// reconnects is the number of times a lost connection was replaced, see
+	// -max-reconnects.
+	var reconnects int
+outerLoop:
+	for i := int64(1); ; i++ {
+		if *coldCmdF != "" {
+			for _, c := range conns {
+				c.Close()
+			}
+			cmd := exec.Command("sh", "-c", *coldCmdF)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("-cold-cmd: %w: %s", err, bytes.TrimSpace(out))
+			}
+			for c := range conns {
+				if conns[c], err = connect(c); err != nil {
+					return err
+				}
+				connPreparedFns[c] = map[*Query]func(context.Context) (measurement, error){}
+			}
+		}
+		// iterConns holds the indexes of the conns used by this iteration.
+		iterConns := []int{int((i - 1) % int64(len(conns)))}
+		if len(dbs) > 1 {
+			iterConns = iterConns[:0]
+			for c := range conns {
+				iterConns = append(iterConns, c)
+			}
+		}
+		for _, c := range iterConns {
+			if *coldF {
+				if err := discardAll(ctx, conns[c]); err != nil {
+					return fmt.Errorf("-cold: %w", err)
+				} else if err := setupSession(conns[c]); err != nil {
+					return err
+				}
+				connPreparedFns[c] = map[*Query]func(context.Context) (measurement, error){}
+			}
+			if *txPerIterationF {
+				if _, err := conns[c].ExecContext(ctx, "BEGIN"); err != nil {
+					return fmt.Errorf("-tx-per-iteration: %w", err)
+				}
+			}
+		}
+
+		var pairSamples [2]int
+		for p, q := range pairQueries {
+			if q != nil {
+				pairSamples[p] = len(q.Seconds)
+			}
+		}
+
+		schedule := bench.Schedule()
+		if finishMsg != "" {
+			// -n or -t was reached, but some queries lack -min-samples.
+			schedule = stalls.Lacking(schedule, *minSamplesF)
+		}
+		for _, query := range schedule {
+			if query.Err != nil {
+				continue
+			}
+
+			connIndex := iterConns[0]
+			if len(dbs) > 1 {
+				connIndex = query.DB
+			}
+			conn, preparedFns := conns[connIndex], connPreparedFns[connIndex]
+			execConn := conn
+			if *freshConnF {
+				if execConn, err = connect(connIndex); err != nil {
+					return err
+				}
+			}
+			opts := durationOpts
+			opts.IncludePlanning = opts.IncludePlanning || query.IncludePlanning
+			opts.RotateParams = query.ParamSets > 0
+			opts.Explained = query.Plan != nil
+			preparedFn := preparedFns[query]
+			if query.Template != nil {
+				rendered, err := renderQueryTemplate(query.Template)
+				if err != nil {
+					return fmt.Errorf("%s: %w", query.Path, err)
+				}
+				opts.Transient = true
+				preparedFn = query.durationFunc(methodFn)(ctx, execConn, rendered, opts)
+			} else if *freshConnF {
+				preparedFn = query.durationFunc(methodFn)(ctx, execConn, query.SQL, opts)
+			} else if preparedFn == nil {
+				preparedFn = query.durationFunc(methodFn)(ctx, conn, query.SQL, opts)
+				preparedFns[query] = preparedFn
+			}
+
+			// queueDelay is the time the query was issued later than
+			// scheduled by -rate.
+			var queueDelay time.Duration
+			if *rateF > 0 {
+				scheduled := runTime.Start.Add(time.Duration(float64(issued) / *rateF * float64(time.Second)))
+				issued++
+				var keepalive func() error
+				if *keepaliveQueryF != "" {
+					keepalive = func() error {
+						_, err := execConn.ExecContext(ctx, *keepaliveQueryF)
+						return err
+					}
+				}
+				// Once -t is reached only a signal can cut short the pacing
+				// of the queries lacking -min-samples.
+				paceCtx := runCtx
+				if finishMsg != "" {
+					paceCtx = interruptCtx
+				}
+				if err := sleepUntil(paceCtx, scheduled, *keepaliveIntervalF, keepalive); paceCtx.Err() != nil {
+					issued--
+					if *freshConnF {
+						execConn.Close()
+					}
+					break
+				} else if err != nil {
+					return fmt.Errorf("-keepalive-query: %w", err)
+				}
+				queueDelay = time.Since(scheduled)
+			}
+
+			// negativeRetries is the number of consecutive negativeTimeErrors
+			// of this execution, see -max-negative-retries.
+			var negativeRetries int
+			for {
+				if sampler != nil {
+					pid, err := backendPID(execConn)
+					if err != nil {
+						return err
+					} else if err := sampler.Begin(query, pid); err != nil {
+						return fmt.Errorf("-backend-stats: %w", err)
+					}
+				}
+				queryCtx, cancel := ctx, context.CancelFunc(func() {})
+				if timeout := *queryTimeoutF + *maxQueryDurationF; timeout > 0 {
+					queryCtx, cancel = context.WithTimeout(ctx, timeout)
+				}
+				m, err := preparedFn(queryCtx)
+				timedOut := queryCtx.Err() == context.DeadlineExceeded
+				cancel()
+				if sampler != nil {
+					sampler.End(err == nil)
+				}
+				if errors.As(err, &negativeTimeError{}) {
+					query.Errors++
+					negativeRetries++
+					if err := retryNegativeTime(os.Stderr, query.Name, err, negativeRetries, *maxNegativeRetriesF); err != nil {
+						return fmt.Errorf("%s: %w", query.Path, err)
+					}
+					continue
+				} else if err != nil && !timedOut && *maxReconnectsF > 0 && isConnectionError(err) {
+					if reconnects >= *maxReconnectsF {
+						return fmt.Errorf("%s: %w (giving up after %d reconnects)", query.Path, err, reconnects)
+					}
+					reconnects++
+					fmt.Fprintf(os.Stderr, "Warning: %s: lost connection, reconnecting: %s\n", query.Path, err)
+					conn.Close()
+					if conn, err = connect(connIndex); err != nil {
+						return fmt.Errorf("-max-reconnects: %w", err)
+					}
+					preparedFns = map[*Query]func(context.Context) (measurement, error){}
+					conns[connIndex], connPreparedFns[connIndex] = conn, preparedFns
+					break
+				} else if err != nil {
+					if timedOut && *maxQueryDurationF > 0 {
+						query.Capped++
+					} else {
+						if timedOut {
+							err = fmt.Errorf("query timeout of %s exceeded: %w", *queryTimeoutF, err)
+						}
+						err = fmt.Errorf("%s: %w", query.Path, pgbouncerHint(err))
+						if *keepGoingF {
+							query.AddFailure(err)
+						} else if !*quietErrorsF {
+							return err
+						} else {
+							fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", query.Name, err)
+							query.Err = err
+						}
+					}
+					if timedOut && !*freshConnF {
+						// pgx closes the connection when a query is canceled
+						// via its context, so we need a new one along with
+						// new prepared statements.
+						conn.Close()
+						if conn, err = connect(connIndex); err != nil {
+							return err
+						}
+						preparedFns = map[*Query]func(context.Context) (measurement, error){}
+						conns[connIndex], connPreparedFns[connIndex] = conn, preparedFns
+					}
+					break
+				}
+				query.AddSample(i, m)
+				if *rateF > 0 {
+					query.AddQueueDelay(queueDelay)
+				}
+				if *seqScanRowsF > 0 && m.Plan != nil {
+					for _, scan := range m.Plan.NewSeqScans(query.FirstPlan, *seqScanRowsF) {
+						if contains(query.SeqScans, scan.RelationName) {
+							continue
+						}
+						query.SeqScans = append(query.SeqScans, scan.RelationName)
+						err := fmt.Errorf("%s: sequential scan on %s reading %.0f rows in iteration %d", query.Path, scan.RelationName, scan.ScannedRows(), i)
+						if *seqScanFailF {
+							return err
+						}
+						fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+					}
+				}
+				if csvW != nil {
+					row := &CSVRow{
+						Iteration: i,
+						Query:     query.Name,
+						Seconds:   m.Duration.Seconds(),
+						SQLHash:   query.SQLHash,
+						Rows:      m.Rows,
+					}
+					if m.Plan != nil {
+						row.Plan = m.Plan.Fingerprint()
+					}
+					if record, err := row.MarshalRecord(outColumns); err != nil {
+						return err
+					} else if err := csvW.Write(record); err != nil {
+						return err
+					}
+					csvRows++
+					if *flushEveryF > 0 && csvRows%*flushEveryF == 0 {
+						csvW.Flush()
+						if err := csvW.Error(); err != nil {
+							return err
+						}
+					}
+				}
+				if jsonlW != nil {
+					if err := jsonlW.Write(newJSONLRecord(i, query, m)); err != nil {
+						return err
+					}
+				}
+				break
+			}
+
+			if *freshConnF {
+				execConn.Close()
+				delete(backendPIDs, execConn)
+			}
+		}
+		if *txPerIterationF {
+			for _, c := range iterConns {
+				if _, err := conns[c].ExecContext(ctx, "COMMIT"); err != nil {
+					return fmt.Errorf("-tx-per-iteration: %w", err)
+				}
+			}
+		}
+
+		if *pairedF {
+			a, b := pairQueries[0], pairQueries[1]
+			if len(a.Seconds) == pairSamples[0]+1 && len(b.Seconds) == pairSamples[1]+1 {
+				pairs[0] = append(pairs[0], a.Seconds[len(a.Seconds)-1])
+				pairs[1] = append(pairs[1], b.Seconds[len(b.Seconds)-1])
+			}
+		}
+
+		skipped = append(skipped, bench.DropFailed()...)
+		if len(bench.Queries) == 0 {
+			exitMsg = "Stopping because all queries failed."
+			break
+		}
+		if i >= *iterationsF && *iterationsF > 0 && finishMsg == "" {
+			finishMsg = fmt.Sprintf("Stopping after %d iterations as requested.", i)
+		}
+		if finishMsg != "" {
+			stalls.Update(stalls.Lacking(bench.Queries, *minSamplesF))
+			if len(stalls.Lacking(bench.Queries, *minSamplesF)) == 0 {
+				exitMsg = finishMsg
+				break
+			}
+		}
+		select {
+		case now := <-drawTicker.C:
+			if sampler != nil {
+				sampler.Apply(bench.Queries)
+			}
+			if err := bench.Update(); err != nil {
+				return err
+			}
+			renderOpts.Unit = holdUnit(renderOpts.Unit, bench.Queries)
+			if *silentF == false {
+				if err := render(bench.Queries, renderOpts); err != nil {
+					return err
+				}
+			}
+			if liveW != nil {
+				if err := liveW.Write(now, bench.Queries); err != nil {
+					return err
+				}
+			}
+			if *targetRSEF > 0 && bench.Converged(*targetRSEF/100, *targetRSEMinF) {
+				exitMsg = fmt.Sprintf("Stopping because the relative standard error of all queries is below %g%%.", *targetRSEF)
+				break outerLoop
+			}
+		case <-runDone:
+			if interruptCtx.Err() != nil {
+				exitMsg = fmt.Sprintf("Stopping due to receiving %s signal.", sig)
+				break outerLoop
+			}
+			runDone = interruptCtx.Done()
+			finishMsg = fmt.Sprintf("Stopping after %s as requested.", secondsD)
+			if len(stalls.Lacking(bench.Queries, *minSamplesF)) == 0 {
+				exitMsg = finishMsg
+				break outerLoop
+			}
+		default:
+		}
+	}
+
+	runTime.End = time.Now()
+	if lacking := lackingSamples(bench.Queries, *minSamplesF); finishMsg != "" && len(lacking) > 0 {
+		var list []string
+		for _, q := range lacking {
+			list = append(list, fmt.Sprintf("%s (%d of %d)", q.Name, q.N(), *minSamplesF))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: -min-samples: gave up on queries lacking samples: %s\n", strings.Join(list, ", "))
+	}
+	if sampler != nil {
+		sampler.Apply(bench.Queries)
+	}
+	if *inTransactionF {
+		for _, c := range conns {
+			if _, err := c.ExecContext(ctx, "COMMIT"); err != nil {
+				return fmt.Errorf("-in-transaction: %w", err)
+			}
+		}
+	}
+	if err := bench.Update(); err != nil {
+		return err
+	}
+	if liveW != nil {
+		if err := liveW.Write(time.Now(), bench.Queries); err != nil {
+			return err
+		}
+	}
+	if *quietF || *formatF == formatBenchstat {
+		if *formatF == formatBenchstat && !*quietF {
+			if err := writeBenchstat(os.Stdout, bench.Queries); err != nil {
+				return err
+			}
+		}
+		// Failed queries are errors, so they're still reported.
+		for _, q := range skipped {
+			fmt.Fprintf(os.Stderr, "Skipped %s: %s\n", q.Name, q.Err)
+		}
+		writeErrorSummary(os.Stderr, bench.Queries)
+	} else {
+		renderOpts.Unit = holdUnit(renderOpts.Unit, bench.Queries)
+		if err := render(bench.Queries, renderOpts); err != nil {
+			return err
+		}
+		fmt.Printf("\n%s\n", exitMsg)
+		fmt.Printf("%s\n", runTime)
+		if *rateF > 0 {
+			fmt.Printf("Issued %d queries at a target rate of %g/s, achieved %.1f/s.\n", issued, *rateF, float64(issued)/runTime.End.Sub(runTime.Start).Seconds())
+		}
+		if *warmupUntilStableF {
+			var warmups []string
+			for _, q := range bench.Queries {
+				warmups = append(warmups, fmt.Sprintf("%s after %d", q.Name, q.WarmupExecutions))
+			}
+			fmt.Printf("Warmed up until stable: %s executions.\n", strings.Join(warmups, ", "))
+		}
+		if reconnects > 0 {
+			fmt.Printf("Reconnected %d times after losing the connection.\n", reconnects)
+		}
+		if *pairedF {
+			if r, err := pairedTTest(pairs[0], pairs[1]); err != nil {
+				fmt.Printf("\n-paired: %s\n", err)
+			} else {
+				fmt.Printf("\n%s\n", r.Summary(pairQueries[0].Name, pairQueries[1].Name))
+			}
+		}
+		unit := resolveUnit(renderOpts.Unit, bench.Queries)
+		if len(rotatedPairs) > 0 {
+			fmt.Printf("\n")
+			for _, pair := range rotatedPairs {
+				fmt.Printf("%s\n", rotatedParamsSummary(pair[0], pair[1], unit))
+			}
+		}
+		if len(clientServerPairs) > 0 {
+			fmt.Printf("\n")
+			for _, pair := range clientServerPairs {
+				fmt.Printf("%s\n", clientServerSummary(pair[0], pair[1], unit))
+			}
+		}
+		for _, q := range bench.Queries {
+			if len(q.PlanChanges) > 0 {
+				fmt.Printf("\n%s: saw %d distinct plans, plan changed during iterations: %s\n", q.Name, len(q.Plans), joinInts(q.PlanChanges))
+			}
+			if len(q.SeqScans) > 0 {
+				fmt.Printf("\n%s: sequential scans on large tables: %s\n", q.Name, strings.Join(q.SeqScans, ", "))
+			}
+			if old, ok := baselinePlans[q.Name]; ok && q.Plan != nil && old.Fingerprint() != q.Plan.Fingerprint() {
+				// The fingerprint covers fields Label() leaves out, so the
+				// diff can be empty even though the fingerprints differ.
+				if diff := planDiff(old, q.Plan); len(diff) > 0 {
+					fmt.Printf("\n%s: plan differs from -plan-diff:\n%s\n", q.Name, strings.Join(diff, "\n"))
+				}
+			}
+			if *explainVerboseF && q.Plan != nil {
+				fmt.Printf("\n%s: plan:\n%s\n", q.Name, strings.Join(verbosePlan(q.Plan), "\n"))
+			}
+			if q.RowEstimateError >= rowEstimateErrorHint && q.Plan != nil {
+				fmt.Printf(
+					"\n%s: row estimate off by %.1fx: estimated %.0f rows at a cost of %.2f, got %.0f rows, consider ANALYZE or extended statistics\n",
+					q.Name, q.RowEstimateError, q.Plan.PlanRows, q.Plan.TotalCost, q.Plan.ActualRows,
+				)
+			}
+			if q.Spills > 0 {
+				fmt.Printf("\n%s: spilled to disk in %d of %d executions, consider increasing work_mem\n", q.Name, q.Spills, q.N())
+			}
+		}
+		if len(skipped) > 0 {
+			fmt.Printf("\nSkipped queries:\n")
+			for _, q := range skipped {
+				fmt.Printf("%s: %s\n", q.Name, q.Err)
+			}
+		}
+		writeErrorSummary(os.Stdout, bench.Queries)
+	}
+
+	// textPlans holds the TEXT plans of the queries, which must be captured
+	// before destroy.sql, see -text-plans.
+	textPlans := map[*Query]string{}
+	if *textPlansF {
+		for _, q := range bench.Queries {
+			query := q.SQL
+			if q.Template != nil {
+				if query, err = renderQueryTemplate(q.Template); err != nil {
+					return fmt.Errorf("%s: %w", q.Path, err)
+				}
+			}
+			if isCallStatement(query) || len(q.Steps) > 0 {
+				continue
+			}
+			plan, err := textPlan(ctx, dbConns[q.DB], query, durationOpts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: -text-plans: %s: %s\n", q.Name, err)
+				continue
+			}
+			textPlans[q] = plan
+		}
+	}
+
+	for _, c := range dbConns {
+		if err := execIndividually(ctx, c, bench.Destroy); err != nil {
+			return err
+		}
+	}
+
+	if *histOutF != "" {
+		if err := writeHistogramCSV(*histOutF, bench.Queries, *histBucketsF); err != nil {
+			return err
+		}
+	}
+
+	if *plansOutF != "" {
+		if err := writePlans(*plansOutF, bench.Queries); err != nil {
+			return err
+		}
+	}
+
+	if *rawOutF != "" {
+		if err := writeRawDurations(*rawOutF, bench.Queries); err != nil {
+			return err
+		}
+	}
+
+	if *resultsDBF != "" {
+		run := resultsRun{
+			Times:  runTime,
+			GitSHA: *gitSHAF,
+			Method: *methodF,
+			Args:   strings.Join(redactArgs(os.Args[1:]), " "),
+			Labels: labels,
+		}
+		if err := writeResultsDB(*resultsDBF, run, bench.Queries); err != nil {
+			return fmt.Errorf("-results-db: %w", err)
+		}
+	}
+
+	if *htmlOutF != "" {
+		if err := writeHTMLReport(*htmlOutF, bench.Queries, renderOpts, runTime); err != nil {
+			return err
+		}
+	}
+
+	if *tableOutF != "" {
+		if err := writeTableCSVFile(*tableOutF, bench.Queries, renderOpts); err != nil {
+			return fmt.Errorf("-table-out: %w", err)
+		}
+	}
+
+	if *verboseF {
+		args := strings.Join(redactArgs(os.Args[1:]), " ")
+		fmt.Printf("\n")
+		for d, db := range dbs {
+			var version string
+			if err := db.QueryRow("SELECT version();").Scan(&version); err != nil {
+				return fmt.Errorf("failed to determine PostgreSQL version: %w", err)
+			}
+			fmt.Printf("postgres version: %s\n", version)
+			if target, err := connTarget(connStrings[d]); err == nil {
+				fmt.Printf("connection: %s\n", target)
+			}
+			if *reportReplicaLagF {
+				status, err := queryReplicaStatus(ctx, db)
+				if err != nil {
+					return fmt.Errorf("-report-replica-lag: %w", err)
+				}
+				fmt.Printf("replica: %s\n", replicaSummary(replicaStart[d], status))
+			}
+		}
+		if *timerOverheadF {
+			fmt.Printf("timer overhead: %s\n", durationOpts.TimerOverhead)
+		}
+		fmt.Printf("seed: %d\n", seed)
+		for _, stmt := range setStmts {
+			fmt.Printf("%s;\n", stmt)
+		}
+		fmt.Printf("sqlbench %s\n\n", args)
+		all := append(append([]*Query{bench.Init}, bench.Queries...), bench.Destroy)
+		for _, q := range all {
+			if q != nil {
+				fmt.Printf("==> %s (sql hash: %s) <==\n", q.Path, q.SQLHash)
+				if q == bench.Init || q == bench.Destroy {
+					fmt.Printf("%s\n", q.SQL)
+					continue
+				}
+				steps := q.Steps
+				if len(steps) == 0 {
+					steps = []string{q.SQL}
+				}
+				for _, step := range steps {
+					method := *methodF
+					if q.Method != "" {
+						method = q.Method
+					}
+					opts := durationOpts
+					opts.IncludePlanning = opts.IncludePlanning || q.IncludePlanning
+					opts.Transient = q.Template != nil
+					for _, stmt := range measuredStatements(method, step, opts) {
+						fmt.Printf("%s;\n", strings.TrimRight(strings.TrimSpace(stmt), ";"))
+					}
+				}
+				if plan, ok := textPlans[q]; ok {
+					fmt.Printf("\n%s\n", plan)
+				}
+			}
+		}
+	}
+
+	if *failOnErrorRateF >= 0 {
+		if err := checkErrorRates(bench.Queries, *failOnErrorRateF); err != nil {
+			return fmt.Errorf("-fail-on-error-rate: %w", err)
+		}
+	}
+	return nil
+}
+
+// runTimes holds the wallclock times at which the benchmark started and
+// finished.
+type runTimes struct {
+	Start time.Time
+	End   time.Time
+}
+
+// String returns a one line summary of the run times.
+func (r runTimes) String() string {
+	return fmt.Sprintf(
+		"Started at %s, finished at %s, took %s.",
+		r.Start.Format(time.RFC3339),
+		r.End.Format(time.RFC3339),
+		r.End.Sub(r.Start).Round(time.Millisecond),
+	)
+}
+
+// openDB returns a database handle for connString. If simpleProtocol is true,
+// the connections use the simple query protocol and no prepared statements,
+// which makes them compatible with PgBouncer's transaction pooling mode. If
+// keepalive is > 0 it's used as the TCP keepalive interval of the connections.
+func openDB(connString string, simpleProtocol bool, keepalive time.Duration) (*sql.DB, error) {
+	if !simpleProtocol && keepalive == 0 {
+		return sql.Open("pgx", connString)
+	}
+	config, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	if simpleProtocol {
+		config.PreferSimpleProtocol = true
+		config.BuildStatementCache = nil
+	}
+	if keepalive > 0 {
+		// Like pgconn's default dialer, but with our keepalive. ConnectTimeout
+		// is zero unless connect_timeout is given.
+		config.DialFunc = (&net.Dialer{KeepAlive: keepalive, Timeout: config.ConnectTimeout}).DialContext
+	}
+	return stdlib.OpenDB(*config), nil
+}
+
+// discardAll executes DISCARD ALL on conn, see -cold. As this also drops the
+// prepared statements behind pgx's statement cache, the cache is cleared
+// beforehand, which deallocates them.
+func discardAll(ctx context.Context, conn *sql.Conn) error {
+	err := conn.Raw(func(driverConn interface{}) error {
+		if cache := driverConn.(*stdlib.Conn).Conn().StatementCache(); cache != nil {
+			return cache.Clear(ctx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "DISCARD ALL")
+	return err
+}
+
+// readConnFile returns the connection string contained in the file at path,
+// or stdin if path is "-".
+func readConnFile(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readOrderFile returns the positions of the query names listed one per line
+// in the file at path, see -order-file. Blank lines are ignored.
+func readOrderFile(path string) (map[string]int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	order := map[string]int{}
+	for _, line := range strings.Split(string(data), "\n") {
+		name := strings.TrimSpace(line)
+		if _, ok := order[name]; name == "" || ok {
+			continue
+		}
+		order[name] = len(order)
+	}
+	return order, nil
+}
+
+// stringList is a flag.Value that collects the values of a flag that can be
+// given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(val string) error {
+	*l = append(*l, val)
+	return nil
+}
+
+// parseLabels returns the key=value pairs given via -label.
+func parseLabels(list []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, label := range list {
+		parts := strings.SplitN(label, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("bad label: %q: must be key=value", label)
+		} else if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("duplicate label: %q", key)
+		}
+		labels[key] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
+
+// formatLabels returns labels as a single line of key=value pairs ordered by
+// key.
+func formatLabels(labels map[string]string) string {
+	var list []string
+	for _, key := range sortedKeys(labels) {
+		list = append(list, key+"="+labels[key])
+	}
+	return strings.Join(list, ", ")
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// settingNamePattern matches valid names of PostgreSQL settings, including
+// custom settings such as "myext.foo".
+var settingNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// validateTransactionFlags returns an error if both -in-transaction and
+// -tx-per-iteration are set, or if one of them is combined with a flag from
+// conflicting. These flags reconnect or keep going after errors, which would
+// abort or lose the open transaction.
+func validateTransactionFlags(inTransaction, txPerIteration bool, conflicting map[string]bool) error {
+	name := "-in-transaction"
+	if txPerIteration {
+		name = "-tx-per-iteration"
+	}
+	if inTransaction && txPerIteration {
+		return errors.New("-in-transaction: can't be combined with -tx-per-iteration")
+	} else if !inTransaction && !txPerIteration {
+		return nil
+	}
+	var flags []string
+	for f, set := range conflicting {
+		if set {
+			flags = append(flags, f)
+		}
+	}
+	if len(flags) > 0 {
+		sort.Strings(flags)
+		return fmt.Errorf("%s: can't be combined with %s", name, strings.Join(flags, ", "))
+	}
+	return nil
+}
+
+// isolationLevels are the transaction isolation levels supported by
+// -isolation. PostgreSQL treats "read uncommitted" like "read committed", so
+// it's not offered.
+var isolationLevels = []string{"read committed", "repeatable read", "serializable"}
+
+// isolationLevel returns the isolation level for the given -isolation value.
+// Case and "-" or "_" instead of spaces are ignored, e.g. REPEATABLE_READ.
+func isolationLevel(val string) (string, error) {
+	level := strings.ToLower(strings.NewReplacer("-", " ", "_", " ").Replace(strings.TrimSpace(val)))
+	if !contains(isolationLevels, level) {
+		return "", fmt.Errorf("unknown isolation level: %q: must be one of %q", val, isolationLevels)
+	}
+	return level, nil
+}
+
+// setStatement returns the statement applying the given "key=value" setting
+// to the session. It uses set_config() rather than SET, because the latter
+// would turn a quoted list value like "a,b" into a single list element.
+func setStatement(setting string) (string, error) {
+	parts := strings.SplitN(setting, "=", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("bad setting: %q: must be key=value", setting)
+	}
+	key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if !settingNamePattern.MatchString(key) {
+		return "", fmt.Errorf("bad setting name: %q", key)
+	}
+	return fmt.Sprintf("SELECT set_config('%s', '%s', false)", key, strings.ReplaceAll(val, "'", "''")), nil
+}
+
+// passwordPattern matches the password of a key=value DSN, or of the query
+// string of a connection URL, which ends at the next "&".
+var passwordPattern = regexp.MustCompile(`(password\s*=\s*)('[^']*'|[^\s&]+)`)
+
+// redactArgs returns a copy of args with all passwords contained in
+// connection URLs or DSNs replaced by "xxxxx".
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = redactConnString(arg)
+	}
+	return redacted
+}
+
+// redactConnString replaces the password in s with "xxxxx" if s looks like a
+// connection URL or DSN. Other strings are returned unmodified.
+func redactConnString(s string) string {
+	if i := strings.Index(s, "://"); i != -1 {
+		// Handle -c=postgres://... style args.
+		prefix, rest := "", s
+		if j := strings.LastIndex(s[:i], "="); j != -1 {
+			prefix, rest = s[:j+1], s[j+1:]
+		}
+		if u, err := url.Parse(rest); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "xxxxx")
+				return prefix + u.String()
+			}
+		}
+	}
+	return passwordPattern.ReplaceAllString(s, "${1}xxxxx")
+}
+
+// connTarget returns a description of the host, port, database and user that
+// connString resolves to, taking PostgreSQL environment variables and service
+// files into account. The password is masked.
+func connTarget(connString string) (string, error) {
+	config, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return "", err
+	}
+	target := fmt.Sprintf("host=%s port=%d database=%s user=%s", config.Host, config.Port, config.Database, config.User)
+	if config.Password != "" {
+		target += " password=****"
+	}
+	return target, nil
+}
+
+// pgbouncerHint adds a hint for using -pgbouncer to err if it looks like it
+// was caused by a connection pooler that doesn't support prepared statements.
+func pgbouncerHint(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+	const (
+		invalidSQLStatementName    = "26000"
+		duplicatePreparedStatement = "42P05"
+	)
+	switch pgErr.Code {
+	case invalidSQLStatementName, duplicatePreparedStatement:
+		return fmt.Errorf("%w (hint: try -pgbouncer if you're connecting via a connection pooler)", err)
+	}
+	return err
+}
+
+// sleepUntil sleeps until t, or until ctx is done, in which case ctx.Err() is
+// returned. If keepalive isn't nil, it's called after every interval of
+// sleeping, as long as more than interval is left, see -keepalive-query.
+func sleepUntil(ctx context.Context, t time.Time, interval time.Duration, keepalive func() error) error {
+	for keepalive != nil && time.Until(t) > interval {
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		} else if err := keepalive(); err != nil {
+			return err
+		}
+	}
+	return sleep(ctx, time.Until(t))
+}
+
+// sleep sleeps for d, or until ctx is done, in which case ctx.Err() is
+// returned.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// negativeRetryWarnEvery is the number of consecutive retries after which
+// retryNegativeTime warns if the retries are unlimited.
+const negativeRetryWarnEvery = 100
+
+// retryNegativeTime returns err if an execution of the query with the given
+// name that failed with the negativeTimeError err for the given number of
+// consecutive times shouldn't be retried anymore, see -max-negative-retries.
+// If maxRetries is 0, a warning is written to w every negativeRetryWarnEvery
+// retries instead, so a stuck run is diagnosable.
+func retryNegativeTime(w io.Writer, name string, err error, retries, maxRetries int) error {
+	if maxRetries > 0 && retries > maxRetries {
+		return fmt.Errorf("%w (giving up after %d retries, see -max-negative-retries)", err, maxRetries)
+	} else if maxRetries == 0 && retries%negativeRetryWarnEvery == 0 {
+		fmt.Fprintf(w, "Warning: %s: retried %d times due to negative times, the clock might be broken: %s\n", name, retries, err)
+	}
+	return nil
+}
+
+// isConnectionError returns true if err indicates that the connection to
+// PostgreSQL was lost, as opposed to an error of the query itself.
+func isConnectionError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+		switch pgErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, "08") // connection_exception
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// joinInts returns a comma separated list of the given numbers.
+func joinInts(nums []int64) string {
+	strs := make([]string, len(nums))
+	for i, n := range nums {
+		strs[i] = strconv.FormatInt(n, 10)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// splitList splits a comma separated list and trims the whitespace around
+// its items. It returns nil for an empty string.
+func splitList(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	items := strings.Split(list, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// contains returns true if list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func LoadBenchmark(paths ...string) (*Benchmark, error) {
+	queries, err := LoadQueries(paths...)
+	if err != nil {
+		return nil, err
+	}
+	b := &Benchmark{}
+	for _, q := range queries {
+		// Our init or destroy SQL might contain non-transactional queries such as
+		// `VACUUM`, so we'll try to execute them one by one. This will fail if a
+		// ';' is contained in a string or similar, but that's probably rarely the
+		// case. We could import a proper PostgreSQL query parser to solve this at
+		// some point.
+		if strings.HasSuffix(q.Name, "init") {
+			b.Init = q
+		} else if strings.HasSuffix(q.Name, "destroy") {
+			b.Destroy = q
+		} else {
+			b.Queries = append(b.Queries, q)
+		}
+	}
+	return b, nil
+}
+
+// splitPrepared returns a "name (prepared)" and a "name (unprepared)" copy of
+// every query, where the latter includes the planning time.
+func splitPrepared(queries []*Query) []*Query {
+	var split []*Query
+	for _, q := range queries {
+		prepared, unprepared := *q, *q
+		prepared.Name += " (prepared)"
+		unprepared.Name += " (unprepared)"
+		unprepared.IncludePlanning = true
+		split = append(split, &prepared, &unprepared)
+	}
+	return split
+}
+
+// splitRotatedParams returns a "name (single params)" and a "name (rotated
+// params)" copy of every query with several "-- params:" directives, where the
+// former always uses the first params and the latter rotates through all of
+// them, see -rotate-params. Other queries are returned as is. The returned
+// pairs hold the single and rotated copy of every split query.
+func splitRotatedParams(queries []*Query) ([]*Query, [][2]*Query) {
+	var (
+		split []*Query
+		pairs [][2]*Query
+	)
+	for _, q := range queries {
+		paramSets := len(queryDirectives(q.SQL, "params"))
+		if paramSets < 2 {
+			split = append(split, q)
+			continue
+		}
+		single, rotated := *q, *q
+		single.Name += " (single params)"
+		rotated.Name += " (rotated params)"
+		rotated.ParamSets = paramSets
+		split = append(split, &single, &rotated)
+		pairs = append(pairs, [2]*Query{&single, &rotated})
+	}
+	return split, pairs
+}
+
+// rotatedParamsSummary returns a one line comparison of the mean duration of
+// the single and rotated copy of a query split by splitRotatedParams, using
+// the given unit.
+func rotatedParamsSummary(single, rotated *Query, unit timeUnit) string {
+	ratio := "n/a"
+	if single.Mean > 0 {
+		ratio = fmt.Sprintf("%.2fx", rotated.Mean/single.Mean)
+	}
+	return fmt.Sprintf(
+		"%s: mean %.2f%s with the first params, %.2f%s rotating through %d params (%s)",
+		strings.TrimSuffix(single.Name, " (single params)"), single.Mean*unit.Scale, unit.Name, rotated.Mean*unit.Scale, unit.Name, rotated.ParamSets, ratio,
+	)
 }
 
-func render(queries []*Query, clear bool, baseline []*Query) error {
-	screen := &bytes.Buffer{}
-
-	if clear {
-		// See https://en.wikipedia.org/wiki/ANSI_escape_code#Terminal_output_sequences
-		// move cursor to 0, 0
-		fmt.Fprintf(screen, "\033[%d;%dH", 0, 0)
-		// reset screen
-		fmt.Fprintf(screen, "\033[2J\033[3J")
+// splitClientServer returns a "name (client)" and a "name (server)" copy of
+// every query, which are measured via -m client and -m explain respectively,
+// see -client-vs-server. The returned pairs hold the client and server copy
+// of every query.
+func splitClientServer(queries []*Query) ([]*Query, [][2]*Query) {
+	var (
+		split []*Query
+		pairs [][2]*Query
+	)
+	for _, q := range queries {
+		client, server := *q, *q
+		client.Name += " (client)"
+		client.Method = "client"
+		server.Name += " (server)"
+		server.Method = "explain"
+		split = append(split, &client, &server)
+		pairs = append(pairs, [2]*Query{&client, &server})
 	}
+	return split, pairs
+}
 
-	headers := []string{""}
-	rows := [][]string{
-		{"n"},
-		{"min"},
-		{"max"},
-		{"mean"},
-		{"stddev"},
-		{"median"},
-		{"p90"},
-		{"p95"},
-		{"errors"},
+// clientServerSummary returns a one line comparison of the mean durations of
+// the client and server copy of a query split by splitClientServer, using
+// the given unit.
+func clientServerSummary(client, server *Query, unit timeUnit) string {
+	overhead := client.Mean - server.Mean
+	share := "n/a"
+	if client.Mean > 0 {
+		share = fmt.Sprintf("%.0f%%", overhead/client.Mean*100)
 	}
+	return fmt.Sprintf(
+		"%s: client mean %.2f%s, server mean %.2f%s, overhead %.2f%s (%s of the client time)",
+		strings.TrimSuffix(client.Name, " (client)"),
+		client.Mean*unit.Scale, unit.Name, server.Mean*unit.Scale, unit.Name, overhead*unit.Scale, unit.Name, share,
+	)
+}
 
-	baselineLookup := map[string]*Query{}
-	for _, query := range baseline {
-		baselineLookup[query.Name] = query
+// writeErrorSummary writes the number of successful and failed executions of
+// all queries that failed at least once to w, along with their error rate,
+// the failures by SQLSTATE and their most recent error, see -keep-going.
+// Nothing is written if no query failed.
+func writeErrorSummary(w io.Writer, queries []*Query) {
+	var failed []*Query
+	for _, q := range queries {
+		if q.Failures > 0 {
+			failed = append(failed, q)
+		}
 	}
-
-	tableFields := func(q *Query) []float64 {
-		const scale = 1000
-		return []float64{
-			q.Min * scale,
-			q.Max * scale,
-			q.Mean * scale,
-			q.StdDev * scale,
-			q.Median * scale,
-			q.P90 * scale,
-			q.P95 * scale,
-			q.Errors,
+	if len(failed) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nFailed queries:\n")
+	for _, q := range failed {
+		var codes string
+		if len(q.FailureCodes) > 0 {
+			var list []string
+			for code, n := range q.FailureCodes {
+				list = append(list, fmt.Sprintf("%s=%d", code, n))
+			}
+			sort.Strings(list)
+			codes = ", by SQLSTATE: " + strings.Join(list, " ")
 		}
+		fmt.Fprintf(w, "%s: %d succeeded, %d failed (%.1f%% error rate%s), last error: %s\n", q.Name, q.N(), q.Failures, q.ErrorRate()*100, codes, q.LastErr)
 	}
+}
 
-	var baselineQuery *Query
-	var baselineFields []float64
-	for i, query := range queries {
-		headers = append(headers, query.Name)
-		fields := tableFields(query)
-
-		if len(baseline) > 0 {
-			baselineQuery = baselineLookup[query.Name]
-			baselineFields = tableFields(baselineQuery)
-		} else if baselineFields == nil {
-			baselineFields = fields
+// checkErrorRates returns an error listing the queries whose error rate
+// exceeds maxPercent, see -fail-on-error-rate.
+func checkErrorRates(queries []*Query, maxPercent float64) error {
+	var exceeded []string
+	for _, q := range queries {
+		if rate := q.ErrorRate() * 100; rate > maxPercent {
+			exceeded = append(exceeded, fmt.Sprintf("%s (%.1f%%)", q.Name, rate))
 		}
+	}
+	if len(exceeded) > 0 {
+		return fmt.Errorf("error rate exceeds %g%%: %s", maxPercent, strings.Join(exceeded, ", "))
+	}
+	return nil
+}
 
-		n := len(query.Seconds)
-		nStr := fmt.Sprintf("%d", n)
-		if baselineQuery != nil {
-			baselineN := len(baselineQuery.Seconds)
-			nStr += fmt.Sprintf(" (%.2fx)", float64(n)/float64(baselineN))
+// splitDatabases returns a "name@label" copy of every query for each of the
+// database labels, see -db.
+func splitDatabases(queries []*Query, labels []string) []*Query {
+	var split []*Query
+	for _, q := range queries {
+		for db, label := range labels {
+			dbQuery := *q
+			dbQuery.Name += "@" + label
+			dbQuery.DB = db
+			split = append(split, &dbQuery)
 		}
-		rows[0] = append(rows[0], nStr)
+	}
+	return split
+}
 
-		for j, field := range fields {
-			var xStr = ""
-			if (i > 0 || baselineQuery != nil) && baselineFields[j] != 0 {
-				xStr = fmt.Sprintf(" (%.2fx)", field/baselineFields[j])
-			}
-			rows[j+1] = append(rows[j+1], fmt.Sprintf("%.2f%s", field, xStr))
+// databaseLabels returns the labels of the -db connection strings. These are
+// the database names, unless they are not unique or unknown, in which case
+// "db1", "db2", ... are used instead.
+func databaseLabels(connStrings []string) []string {
+	var (
+		labels []string
+		seen   = map[string]bool{}
+	)
+	for _, connString := range connStrings {
+		config, err := pgx.ParseConfig(connString)
+		if err != nil || config.Database == "" || seen[config.Database] {
+			labels = nil
+			break
 		}
+		seen[config.Database] = true
+		labels = append(labels, config.Database)
 	}
-
-	table := tablewriter.NewWriter(screen)
-	table.SetAutoFormatHeaders(false)
-	table.SetHeader(headers)
-	table.SetBorder(false)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.AppendBulk(rows)
-	table.Render()
-	screen.WriteTo(os.Stdout)
-	return nil
+	if labels == nil {
+		for i := range connStrings {
+			labels = append(labels, fmt.Sprintf("db%d", i+1))
+		}
+	}
+	return labels
 }
 
-func LoadBenchmark(paths ...string) (*Benchmark, error) {
+// LoadBenchmarkExplicit is like LoadBenchmark, but takes the init and destroy
+// files from the given paths rather than classifying them by name, so all
+// of the remaining paths are benchmarked. initPath and destroyPath are
+// optional.
+func LoadBenchmarkExplicit(initPath, destroyPath string, paths ...string) (*Benchmark, error) {
 	queries, err := LoadQueries(paths...)
 	if err != nil {
 		return nil, err
 	}
-	b := &Benchmark{}
-	for _, q := range queries {
-		// Our init or destroy SQL might contain non-transactional queries such as
-		// `VACUUM`, so we'll try to execute them one by one. This will fail if a
-		// ';' is contained in a string or similar, but that's probably rarely the
-		// case. We could import a proper PostgreSQL query parser to solve this at
-		// some point.
-		if strings.HasSuffix(q.Name, "init") {
-			b.Init = q
-		} else if strings.HasSuffix(q.Name, "destroy") {
-			b.Destroy = q
-		} else {
-			b.Queries = append(b.Queries, q)
+	b := &Benchmark{Queries: queries}
+	if initPath != "" {
+		if b.Init, err = loadQuery(initPath); err != nil {
+			return nil, err
+		}
+	}
+	if destroyPath != "" {
+		if b.Destroy, err = loadQuery(destroyPath); err != nil {
+			return nil, err
 		}
 	}
 	return b, nil
@@ -334,23 +2495,203 @@ func LoadQueries(paths ...string) ([]*Query, error) {
 		q, err := loadQuery(path)
 		if err != nil {
 			return nil, err
+		} else if strings.TrimSpace(stripSQLComments(q.SQL)) == "" {
+			return nil, fmt.Errorf("%s: file contains no SQL", path)
 		}
 		queries = append(queries, q)
 	}
 	return queries, nil
 }
 
+// nameFromComments renames the queries that have a "-- name:" comment after
+// it, see -name-from-comment. It returns an error if the names aren't unique.
+func nameFromComments(queries []*Query) error {
+	paths := map[string]string{}
+	for _, q := range queries {
+		if name, ok := queryDirective(q.SQL, "name"); ok && name != "" {
+			q.Name = name
+		}
+		if path, ok := paths[q.Name]; ok {
+			return fmt.Errorf("%s and %s have the same name: %q", path, q.Path, q.Name)
+		}
+		paths[q.Name] = q.Path
+	}
+	return nil
+}
+
+// parseWorkload parses a -workload spec of the form name=a.sql,b.sql.
+func parseWorkload(spec string) (string, []string, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", nil, fmt.Errorf("bad workload: %q: must be name=a.sql,b.sql", spec)
+	}
+	paths := splitList(parts[1])
+	if len(paths) == 0 {
+		return "", nil, fmt.Errorf("bad workload: %q: no files", spec)
+	}
+	return strings.TrimSpace(parts[0]), paths, nil
+}
+
+// loadWorkload returns a query named name whose Steps are the SQL of the
+// given paths, see -workload.
+func loadWorkload(name string, paths ...string) (*Query, error) {
+	steps, err := LoadQueries(paths...)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{Path: strings.Join(paths, ","), Name: name, Weight: 1}
+	for _, step := range steps {
+		q.Steps = append(q.Steps, step.SQL)
+	}
+	q.SQL = strings.Join(q.Steps, "\n")
+	q.SQLHash = sqlHash(q.SQL)
+	return q, nil
+}
+
 func loadQuery(path string) (*Query, error) {
 	sql, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-	return &Query{
-		Path: path,
-		Name: name,
-		SQL:  string(sql),
-	}, nil
+	q := &Query{
+		Path:    path,
+		Name:    name,
+		SQL:     string(sql),
+		SQLHash: sqlHash(string(sql)),
+		Weight:  1,
+	}
+	if val, ok := queryDirective(q.SQL, "weight"); ok {
+		weight, err := strconv.Atoi(val)
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("%s: bad weight: %q: must be an integer >= 1", path, val)
+		}
+		q.Weight = weight
+	}
+	return q, nil
+}
+
+// stripSQLComments returns sql without its "--" and "/* */" comments. It
+// doesn't understand string literals, so it's only suitable for heuristics.
+func stripSQLComments(sql string) string {
+	var sb strings.Builder
+	for len(sql) > 0 {
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			end := strings.IndexByte(sql, '\n')
+			if end == -1 {
+				end = len(sql)
+			}
+			sql = sql[end:]
+		case strings.HasPrefix(sql, "/*"):
+			end := strings.Index(sql, "*/")
+			if end == -1 {
+				end = len(sql)
+			} else {
+				end += len("*/")
+			}
+			sql = sql[end:]
+		default:
+			sb.WriteByte(sql[0])
+			sql = sql[1:]
+		}
+	}
+	return sb.String()
+}
+
+// isCallStatement returns true if sql is a CALL statement invoking a stored
+// procedure.
+func isCallStatement(sql string) bool {
+	fields := strings.Fields(stripSQLComments(sql))
+	return len(fields) > 0 && strings.EqualFold(fields[0], "CALL")
+}
+
+// checkCallStatements returns an error if query or one of its workload steps
+// is a CALL statement, and the given method or flags would rewrite it into
+// something PostgreSQL can't execute.
+func checkCallStatements(query *Query, method string, clientVsServer, explainOnce, countOnly bool) error {
+	statements := query.Steps
+	if len(statements) == 0 {
+		statements = []string{query.SQL}
+	}
+	for _, sql := range statements {
+		if !isCallStatement(sql) {
+			continue
+		} else if method == "explain" {
+			return errors.New("CALL statements can't be measured with -m explain because PostgreSQL can't EXPLAIN them: use -m client instead")
+		} else if clientVsServer {
+			return errors.New("-client-vs-server: CALL statements can't be explained")
+		} else if explainOnce {
+			return errors.New("-explain-once: CALL statements can't be explained")
+		} else if method == "batch" {
+			return errors.New("CALL statements can't be measured with -m batch because PERFORM can't execute them: use -m client instead")
+		} else if method == "server" {
+			return errors.New("CALL statements can't be measured with -m server because PostgreSQL can't EXPLAIN them: use -m client instead")
+		} else if method == "prepare" {
+			return errors.New("CALL statements can't be measured with -m prepare because PostgreSQL can't PREPARE them: use -m client instead")
+		} else if countOnly {
+			return errors.New("-count-only: CALL statements can't be wrapped in SELECT count(*)")
+		}
+	}
+	return nil
+}
+
+// sqlHash returns a short fingerprint of sql that allows to detect changes to
+// a query between runs.
+func sqlHash(sql string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(sql)))[:8]
+}
+
+// queryDirective returns the value of a "-- key: value" comment contained in
+// the leading comment lines of sql. If there are several, the first one is
+// returned.
+func queryDirective(sql, key string) (string, bool) {
+	if values := queryDirectives(sql, key); len(values) > 0 {
+		return values[0], true
+	}
+	return "", false
+}
+
+// queryDirectives returns the values of all "-- key: value" comments
+// contained in the leading comment lines of sql.
+func queryDirectives(sql, key string) []string {
+	var values []string
+	for _, line := range strings.Split(sql, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		} else if !strings.HasPrefix(line, "--") {
+			break
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			values = append(values, strings.TrimSpace(parts[1]))
+		}
+	}
+	return values
+}
+
+// countOnlySQL wraps sql as SELECT count(*) FROM (sql) so that only a single
+// row is transferred to the client, see -count-only. Leading comment lines are
+// kept in front, so directives such as "-- params:" still apply.
+func countOnlySQL(sql string) string {
+	lines := strings.Split(sql, "\n")
+	var header int
+	for header < len(lines) {
+		line := strings.TrimSpace(lines[header])
+		if line != "" && !strings.HasPrefix(line, "--") {
+			break
+		}
+		header++
+	}
+	body := strings.TrimRight(strings.TrimSpace(strings.Join(lines[header:], "\n")), ";")
+	// The closing parenthesis goes on its own line in case the body ends
+	// with a comment.
+	wrapped := "SELECT count(*) FROM (\n" + body + "\n) sqlbench_count_only;"
+	if header == 0 {
+		return wrapped
+	}
+	return strings.Join(lines[:header], "\n") + "\n" + wrapped
 }
 
 type Benchmark struct {
@@ -360,37 +2701,528 @@ type Benchmark struct {
 	Queries []*Query
 	// Destroy SQL query to execute after finishing the benchmark.
 	Destroy *Query
+	// SortBy returns the stat the queries are sorted by in ascending order,
+	// see -sort. nil means sorting by Mean.
+	SortBy func(q *Query) float64
+	// Order maps query names to their position, see -order-file. If set, it
+	// takes precedence over SortBy.
+	Order map[string]int
 }
 
 // Update updates the stats of all queries and sorts them by mean execution
-// time in ascending order.
+// time in ascending order, or by Order if set.
 func (b *Benchmark) Update() error {
 	for _, query := range b.Queries {
-		if err := query.UpdateStats(); err != nil {
+		// Queries may have no samples yet, e.g. when all of their executions
+		// exceeded -max-query-duration.
+		if len(query.Seconds) == 0 {
+			continue
+		} else if err := query.UpdateStats(); err != nil {
 			return err
 		}
 	}
 
-	sort.Slice(b.Queries, func(i, j int) bool {
-		return b.Queries[i].Mean < b.Queries[j].Mean
+	sortBy := b.SortBy
+	if sortBy == nil {
+		sortBy = func(q *Query) float64 { return q.Mean }
+	}
+	sort.SliceStable(b.Queries, func(i, j int) bool {
+		qi, qj := b.Queries[i], b.Queries[j]
+		if len(qi.Seconds) == 0 || len(qj.Seconds) == 0 {
+			return len(qj.Seconds) == 0 && len(qi.Seconds) > 0
+		}
+		return sortBy(qi) < sortBy(qj)
 	})
+	if b.Order != nil {
+		rank := func(q *Query) int {
+			if pos, ok := b.Order[q.Name]; ok {
+				return pos
+			}
+			return len(b.Order)
+		}
+		sort.SliceStable(b.Queries, func(i, j int) bool {
+			return rank(b.Queries[i]) < rank(b.Queries[j])
+		})
+	}
+	return nil
+}
+
+// Filter removes all queries whose names don't match any of the only patterns
+// (unless empty) or match any of the exclude patterns. Patterns use the syntax
+// of filepath.Match. Init and Destroy are not affected.
+func (b *Benchmark) Filter(only, exclude []string) error {
+	matchAny := func(patterns []string, name string) (bool, error) {
+		for _, pattern := range patterns {
+			if ok, err := filepath.Match(pattern, name); err != nil {
+				return false, fmt.Errorf("bad pattern: %q: %w", pattern, err)
+			} else if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	var queries []*Query
+	for _, query := range b.Queries {
+		if included, err := matchAny(only, query.Name); err != nil {
+			return err
+		} else if len(only) > 0 && !included {
+			continue
+		} else if excluded, err := matchAny(exclude, query.Name); err != nil {
+			return err
+		} else if excluded {
+			continue
+		}
+		queries = append(queries, query)
+	}
+	if len(b.Queries) > 0 && len(queries) == 0 {
+		return errors.New("no queries left after applying -only and -exclude")
+	}
+	b.Queries = queries
 	return nil
 }
 
+// DropFailed removes all queries with a non-nil Err from the benchmark and
+// returns them.
+func (b *Benchmark) DropFailed() []*Query {
+	var ok, failed []*Query
+	for _, query := range b.Queries {
+		if query.Err != nil {
+			failed = append(failed, query)
+		} else {
+			ok = append(ok, query)
+		}
+	}
+	b.Queries = ok
+	return failed
+}
+
+// Converged returns true if every query has at least minSamples samples and
+// a relative standard error (SEM/Mean) of at most maxRSE. The stats must be
+// up to date, see Update.
+func (b *Benchmark) Converged(maxRSE float64, minSamples int) bool {
+	for _, query := range b.Queries {
+		if query.N() < minSamples || query.SEM > maxRSE*query.Mean {
+			return false
+		}
+	}
+	return true
+}
+
+// lackingSamples returns the queries with fewer than minSamples samples, in
+// the same order.
+func lackingSamples(queries []*Query, minSamples int) []*Query {
+	var lacking []*Query
+	for _, query := range queries {
+		if query.N() < minSamples {
+			lacking = append(lacking, query)
+		}
+	}
+	return lacking
+}
+
+// minSamplesStallIterations is the number of consecutive iterations without a
+// new sample after which -min-samples gives up on a query.
+const minSamplesStallIterations = 10
+
+// sampleStallTracker detects queries that stopped getting new samples while
+// the benchmark keeps going for -min-samples.
+type sampleStallTracker struct {
+	// max is the number of consecutive iterations without a new sample after
+	// which a query is considered stalled.
+	max     int
+	samples map[*Query]int
+	stalls  map[*Query]int
+}
+
+// Update must be called after every iteration with the queries that are still
+// lacking samples.
+func (t *sampleStallTracker) Update(lacking []*Query) {
+	if t.samples == nil {
+		t.samples, t.stalls = map[*Query]int{}, map[*Query]int{}
+	}
+	for _, q := range lacking {
+		if n, ok := t.samples[q]; ok && n == q.N() {
+			t.stalls[q]++
+		} else {
+			t.stalls[q] = 0
+		}
+		t.samples[q] = q.N()
+	}
+}
+
+// Lacking is like lackingSamples, but omits the stalled queries.
+func (t *sampleStallTracker) Lacking(queries []*Query, minSamples int) []*Query {
+	var lacking []*Query
+	for _, q := range lackingSamples(queries, minSamples) {
+		if t.stalls[q] < t.max {
+			lacking = append(lacking, q)
+		}
+	}
+	return lacking
+}
+
+// Schedule returns the order in which the queries are executed during a
+// single iteration. Each query appears as often as its Weight, and queries
+// are interleaved as evenly as possible using smooth weighted round-robin.
+func (b *Benchmark) Schedule() []*Query {
+	var total int
+	for _, query := range b.Queries {
+		total += query.Weight
+	}
+
+	schedule := make([]*Query, 0, total)
+	current := make([]int, len(b.Queries))
+	for len(schedule) < total {
+		best := 0
+		for i, query := range b.Queries {
+			current[i] += query.Weight
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, b.Queries[best])
+	}
+	return schedule
+}
+
 type Query struct {
 	Path string
 	Name string
 	SQL  string
+	// SQLHash is the sqlHash of SQL. For baseline queries it's taken from the
+	// CSV file, if available.
+	SQLHash string
+	// Weight is the number of times the query is executed per iteration, it
+	// can be set via a "-- weight: N" comment.
+	Weight int
+	// Template is set if the SQL contains template actions that generate a
+	// new variant of the query for every execution, see parseQueryTemplate.
+	Template *template.Template
+	// IncludePlanning causes the planning time to be included for this query
+	// even without -p, see -prepared-vs-unprepared.
+	IncludePlanning bool
+	// WarmupExecutions is the number of unrecorded executions before the
+	// benchmark, see -warmup-until-stable.
+	WarmupExecutions int
+	// ParamSets is the number of "-- params:" directives that are rotated
+	// through on successive executions, or 0, see -rotate-params.
+	ParamSets int
+	// Steps holds the SQL of the queries of a -workload, which are measured
+	// together. SQL holds all of them in this case.
+	Steps []string
+	// Method overrides the -m method of the query, see -client-vs-server.
+	Method string
+	// DB is the index of the -db database the query is executed against.
+	DB int
+	// Err is the error that caused the query to be dropped from the
+	// benchmark, see -quiet-errors.
+	Err error
+	// Failures is the number of failed executions and LastErr the error of
+	// the most recent one, see -keep-going. FailureCodes counts the failures
+	// by SQLSTATE, e.g. 40P01 for deadlocks.
+	Failures     int64
+	LastErr      error
+	FailureCodes map[string]int64
 
 	Seconds []float64
-	Min     float64
-	Max     float64
-	Mean    float64
-	Median  float64
-	StdDev  float64
-	P90     float64
-	P95     float64
-	Errors  float64
+	// Hist holds all durations once Seconds has histogramAfter samples, in
+	// which case no further samples are added to Seconds or the other
+	// per-sample slices.
+	Hist *durationHistogram
+	// Rows holds the number of rows processed for each sample in Seconds. It's
+	// empty if the method doesn't report rows.
+	Rows []float64
+
+	Min    float64
+	Max    float64
+	Mean   float64
+	Median float64
+	StdDev float64
+	// SEM is the standard error of the mean.
+	SEM float64
+	// TrimmedMean and TrimmedStdDev exclude the samples discarded by
+	// -trim-percent.
+	TrimmedMean   float64
+	TrimmedStdDev float64
+	// Percentiles holds the values of the statPercentiles, in the same
+	// order.
+	Percentiles []float64
+	Errors      float64
+	// Capped is the number of executions discarded for exceeding
+	// -max-query-duration.
+	Capped int64
+	// RowsPerSecond is the throughput computed from Rows and Seconds.
+	RowsPerSecond float64
+	// PlanningSeconds and ExecutionSeconds hold the planning and execution
+	// times reported by PostgreSQL for each measurement, and PlanningMean and
+	// ExecutionMean their means. Only available for -m explain.
+	PlanningSeconds  []float64
+	ExecutionSeconds []float64
+	PlanningMean     float64
+	ExecutionMean    float64
+	// ReadBlocks holds the number of shared blocks read by each sample, and
+	// ReadsPerRow the total ReadBlocks per row returned. Only available for
+	// -explain-buffers-summary.
+	ReadBlocks  []float64
+	ReadsPerRow float64
+	// TempBlocks holds the number of temporary blocks read and written by each
+	// sample, and TempBlocksMean its mean. Only available for
+	// -explain-buffers-summary.
+	TempBlocks     []float64
+	TempBlocksMean float64
+	// FirstRowSeconds holds the time until the first row was received for
+	// each measurement, and FirstRowMean its mean. Only available for -m
+	// client.
+	FirstRowSeconds []float64
+	FirstRowMean    float64
+	// QueueSeconds holds the time each sample was issued later than scheduled
+	// due to the previous query still running, and QueueMean and QueueMax its
+	// mean and max. Only available for -rate.
+	QueueSeconds []float64
+	QueueMean    float64
+	QueueMax     float64
+	// CorrectedSeconds holds the durations measured from the scheduled rather
+	// than the actual start of each sample, i.e. including the queueing delay,
+	// and CorrectedPercentiles the statPercentiles of them. Unlike Seconds
+	// these don't suffer from coordinated omission: a stalled query delays
+	// all subsequent queries. Only available for -rate.
+	CorrectedSeconds     []float64
+	CorrectedPercentiles []float64
+	// BackendCPU holds the CPU time used by the backend process for each
+	// measurement, and BackendCPUMean its mean. BackendPeakRSS and
+	// BackendMeanRSS are the peak and mean resident memory of the backend in
+	// bytes while executing the query. Only available for -backend-stats.
+	BackendCPU     []float64
+	BackendCPUMean float64
+	BackendPeakRSS float64
+	BackendMeanRSS float64
+	// MinIteration and MaxIteration are the iterations during which the Min
+	// and Max durations were measured.
+	MinIteration int64
+	MaxIteration int64
+	// Warmup is the number of leading samples detected as ramp-up, see
+	// warmupSamples. SteadyMean and SteadyMedian exclude these samples.
+	Warmup       int
+	SteadyMean   float64
+	SteadyMedian float64
+
+	// Plan is the most recent query plan. Only available for -m explain.
+	Plan *explainPlan
+	// FirstPlan is the first query plan, see -seq-scan-rows.
+	FirstPlan *explainPlan
+	// Plans is the set of distinct plan fingerprints seen so far.
+	Plans map[string]bool
+	// PlanChanges holds the iterations during which the plan differed from
+	// the plan of the previous execution.
+	PlanChanges []int64
+	// SeqScans holds the tables that were read by a sequential scan exceeding
+	// -seq-scan-rows that wasn't in FirstPlan.
+	SeqScans []string
+	// Spills is the number of samples whose plan spilled to disk, see
+	// explainPlan.Spills. Only available for -m explain.
+	Spills int64
+	// RowEstimateErrors holds the explainPlan.RowEstimateError of the top
+	// node of each sample, and RowEstimateError its mean. Only available for
+	// -m explain.
+	RowEstimateErrors []float64
+	RowEstimateError  float64
+
+	// minIndex and maxIndex are the indexes of the min and max values in
+	// Seconds.
+	minIndex int
+	maxIndex int
+	// planFingerprint is the fingerprint of Plan.
+	planFingerprint string
+	// readBlocksRows is the total number of rows of the samples in
+	// ReadBlocks.
+	readBlocksRows float64
+}
+
+// rowEstimateErrorHint is the mean Query.RowEstimateError from which a hint
+// about the misestimate is displayed after the benchmark.
+const rowEstimateErrorHint = 10
+
+// histogramAfter is the number of samples after which the durations of a
+// query are recorded into its Hist, or 0, see -histogram-after.
+var histogramAfter int
+
+// trimPercent is the percentage of samples discarded from each tail for the
+// trimmed stats, see -trim-percent.
+var trimPercent float64
+
+// trimSamples returns a sorted copy of seconds without the given percentage
+// of the smallest and largest values. At least one value is kept as long as
+// percent is below 50.
+func trimSamples(seconds []float64, percent float64) []float64 {
+	sorted := append([]float64(nil), seconds...)
+	sort.Float64s(sorted)
+	n := int(float64(len(sorted)) * percent / 100)
+	return sorted[n : len(sorted)-n]
+}
+
+// computePercentiles returns dst[:0] with the statPercentiles of data
+// appended, in the same order.
+func computePercentiles(dst, data []float64) ([]float64, error) {
+	dst = dst[:0]
+	for _, p := range statPercentiles {
+		val, err := stats.Percentile(data, p)
+		if err == stats.BoundsErr {
+			// Percentiles below the first sample are not interpolated.
+			val, err = stats.Min(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, val)
+	}
+	return dst, nil
+}
+
+// correctedPercentile is like percentile, but for the CorrectedPercentiles.
+func (q *Query) correctedPercentile(i int) float64 {
+	if i >= len(q.CorrectedPercentiles) {
+		return 0
+	}
+	return q.CorrectedPercentiles[i]
+}
+
+// percentile returns the value of the i-th statPercentiles, or 0 if the stats
+// haven't been computed yet.
+func (q *Query) percentile(i int) float64 {
+	if i >= len(q.Percentiles) {
+		return 0
+	}
+	return q.Percentiles[i]
+}
+
+// durationFunc returns fn, or the function of q's Method if set, wrapped in a
+// workloadDuration if q is a -workload.
+func (q *Query) durationFunc(fn queryDurationFunc) queryDurationFunc {
+	if q.Method != "" {
+		fn = queryDurationFuncs[q.Method]
+	}
+	if len(q.Steps) == 0 {
+		return fn
+	}
+	return workloadDuration(fn, q.Steps)
+}
+
+// AddFailure records a failed execution, see -keep-going.
+func (q *Query) AddFailure(err error) {
+	q.Failures++
+	q.LastErr = err
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if q.FailureCodes == nil {
+			q.FailureCodes = map[string]int64{}
+		}
+		q.FailureCodes[pgErr.Code]++
+	}
+}
+
+// N returns the number of samples of q, including those only recorded in
+// Hist.
+func (q *Query) N() int {
+	if q.Hist != nil {
+		return int(q.Hist.Count)
+	}
+	return len(q.Seconds)
+}
+
+// ErrorRate returns the fraction of the executions of q that failed.
+func (q *Query) ErrorRate() float64 {
+	total := float64(q.N()) + float64(q.Failures)
+	if total == 0 {
+		return 0
+	}
+	return float64(q.Failures) / total
+}
+
+// AddSample records a measurement taken during the given iteration.
+func (q *Query) AddSample(iteration int64, m measurement) {
+	seconds := m.Duration.Seconds()
+	if q.Hist == nil && histogramAfter > 0 && len(q.Seconds) >= histogramAfter {
+		q.Hist = newDurationHistogram()
+		for _, s := range q.Seconds {
+			q.Hist.Record(s)
+		}
+	}
+	if q.Hist != nil {
+		if seconds < q.Hist.Min {
+			q.MinIteration = iteration
+		} else if seconds > q.Hist.Max {
+			q.MaxIteration = iteration
+		}
+		q.Hist.Record(seconds)
+		if m.Plan != nil {
+			if m.Plan.Spills() {
+				q.Spills++
+			}
+			q.addPlan(iteration, m.Plan)
+		}
+		return
+	}
+	if len(q.Seconds) == 0 || seconds < q.Seconds[q.minIndex] {
+		q.minIndex = len(q.Seconds)
+		q.MinIteration = iteration
+	}
+	if len(q.Seconds) == 0 || seconds > q.Seconds[q.maxIndex] {
+		q.maxIndex = len(q.Seconds)
+		q.MaxIteration = iteration
+	}
+	q.Seconds = append(q.Seconds, seconds)
+	if m.Rows >= 0 {
+		q.Rows = append(q.Rows, m.Rows)
+	}
+	if m.Plan != nil && m.Plan.SharedReadBlocks != nil {
+		q.ReadBlocks = append(q.ReadBlocks, *m.Plan.SharedReadBlocks)
+		q.readBlocksRows += m.Plan.ActualRows
+	}
+	if m.Plan != nil {
+		if blocks, ok := m.Plan.TempBlocks(); ok {
+			q.TempBlocks = append(q.TempBlocks, blocks)
+		}
+		if m.Plan.Spills() {
+			q.Spills++
+		}
+		q.RowEstimateErrors = append(q.RowEstimateErrors, m.Plan.RowEstimateError())
+	}
+	if m.Planning >= 0 && m.Execution >= 0 {
+		q.PlanningSeconds = append(q.PlanningSeconds, m.Planning.Seconds())
+		q.ExecutionSeconds = append(q.ExecutionSeconds, m.Execution.Seconds())
+	}
+	if m.FirstRow >= 0 {
+		q.FirstRowSeconds = append(q.FirstRowSeconds, m.FirstRow.Seconds())
+	}
+	if m.Plan != nil {
+		q.addPlan(iteration, m.Plan)
+	}
+}
+
+// addPlan records the plan of a sample taken during the given iteration.
+func (q *Query) addPlan(iteration int64, plan *explainPlan) {
+	fingerprint := plan.Fingerprint()
+	if q.Plan != nil && q.planFingerprint != fingerprint {
+		q.PlanChanges = append(q.PlanChanges, iteration)
+	}
+	if q.Plans == nil {
+		q.Plans = map[string]bool{}
+	}
+	q.Plans[fingerprint] = true
+	if q.FirstPlan == nil {
+		q.FirstPlan = plan
+	}
+	q.Plan = plan
+	q.planFingerprint = fingerprint
+}
+
+// AddQueueDelay records the queueing delay of the most recent sample, see
+// -rate.
+func (q *Query) AddQueueDelay(d time.Duration) {
+	q.QueueSeconds = append(q.QueueSeconds, d.Seconds())
+	q.CorrectedSeconds = append(q.CorrectedSeconds, q.Seconds[len(q.Seconds)-1]+d.Seconds())
 }
 
 func (q *Query) UpdateStats() error {
@@ -411,18 +3243,76 @@ func (q *Query) UpdateStats() error {
 	if err != nil {
 		return err
 	}
+	q.SEM = q.StdDev / math.Sqrt(float64(len(q.Seconds)))
 	q.Median, err = stats.Median(q.Seconds)
 	if err != nil {
 		return err
 	}
-	q.P90, err = stats.Percentile(q.Seconds, 90)
+	if trimPercent > 0 {
+		trimmed := trimSamples(q.Seconds, trimPercent)
+		if q.TrimmedMean, err = stats.Mean(trimmed); err != nil {
+			return err
+		} else if q.TrimmedStdDev, err = stats.StdDevS(trimmed); err != nil {
+			return err
+		}
+	}
+	if q.Percentiles, err = computePercentiles(q.Percentiles, q.Seconds); err != nil {
+		return err
+	}
+	if q.Hist != nil {
+		q.Min, q.Max, q.Mean, q.StdDev = q.Hist.Min, q.Hist.Max, q.Hist.Mean(), q.Hist.StdDev()
+		q.SEM = q.StdDev / math.Sqrt(float64(q.Hist.Count))
+		q.Median = q.Hist.Percentile(50)
+		for i, p := range statPercentiles {
+			q.Percentiles[i] = q.Hist.Percentile(p)
+		}
+	}
+	if len(q.CorrectedSeconds) > 0 {
+		if q.CorrectedPercentiles, err = computePercentiles(q.CorrectedPercentiles, q.CorrectedSeconds); err != nil {
+			return err
+		}
+	}
+	if len(q.ReadBlocks) > 0 && q.readBlocksRows > 0 {
+		reads, _ := stats.Sum(q.ReadBlocks)
+		q.ReadsPerRow = reads / q.readBlocksRows
+	}
+	if len(q.PlanningSeconds) > 0 {
+		q.PlanningMean, _ = stats.Mean(q.PlanningSeconds)
+		q.ExecutionMean, _ = stats.Mean(q.ExecutionSeconds)
+	}
+	if len(q.FirstRowSeconds) > 0 {
+		q.FirstRowMean, _ = stats.Mean(q.FirstRowSeconds)
+	}
+	if len(q.QueueSeconds) > 0 {
+		q.QueueMean, _ = stats.Mean(q.QueueSeconds)
+		q.QueueMax, _ = stats.Max(q.QueueSeconds)
+	}
+	if len(q.TempBlocks) > 0 {
+		q.TempBlocksMean, _ = stats.Mean(q.TempBlocks)
+	}
+	if len(q.RowEstimateErrors) > 0 {
+		q.RowEstimateError, _ = stats.Mean(q.RowEstimateErrors)
+	}
+	if len(q.BackendCPU) > 0 {
+		q.BackendCPUMean, _ = stats.Mean(q.BackendCPU)
+	}
+	q.Warmup = warmupSamples(q.Seconds)
+	steady := q.Seconds[q.Warmup:]
+	q.SteadyMean, err = stats.Mean(steady)
 	if err != nil {
 		return err
 	}
-	q.P95, err = stats.Percentile(q.Seconds, 95)
+	q.SteadyMedian, err = stats.Median(steady)
 	if err != nil {
 		return err
 	}
+	if len(q.Rows) > 0 {
+		rows, _ := stats.Sum(q.Rows)
+		seconds, _ := stats.Sum(q.Seconds)
+		if seconds > 0 {
+			q.RowsPerSecond = rows / seconds
+		}
+	}
 	return nil
 }
 
@@ -440,31 +3330,79 @@ func execIndividually(ctx context.Context, conn *sql.Conn, q *Query) error {
 	return nil
 }
 
-// loadBaseline loads the query measurements contained in the csvPath file. The
-// resulting Query structs don't have the Path or SQL field populated.
-func loadBaseline(csvPath string) ([]*Query, error) {
-	rows, err := loadCSVRows(csvPath)
-	if err != nil {
-		return nil, err
+// baselineDiff returns the names of the queries that only exist in queries
+// or only in baseline.
+func baselineDiff(queries, baseline []*Query) (onlyCurrent, onlyBaseline []string) {
+	for _, q := range queries {
+		if findQuery(baseline, q.Name) == nil {
+			onlyCurrent = append(onlyCurrent, q.Name)
+		}
+	}
+	for _, q := range baseline {
+		if findQuery(queries, q.Name) == nil {
+			onlyBaseline = append(onlyBaseline, q.Name)
+		}
+	}
+	return onlyCurrent, onlyBaseline
+}
+
+// warnBaselineDiff prints a warning to stderr if queries and baseline don't
+// contain the same set of queries, since this makes comparisons misleading.
+func warnBaselineDiff(queries, baseline []*Query) {
+	onlyCurrent, onlyBaseline := baselineDiff(queries, baseline)
+	if len(onlyCurrent) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: queries missing from the baseline: %s\n", strings.Join(onlyCurrent, ", "))
 	}
+	if len(onlyBaseline) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: baseline queries missing from the current run: %s\n", strings.Join(onlyBaseline, ", "))
+	}
+}
 
+// loadBaseline loads the query measurements contained in the csvPath file, as
+// well as its metadata, see writeCSVMetadata. The resulting Query structs
+// don't have the Path or SQL field populated.
+func loadBaseline(csvPath string) ([]*Query, map[string]string, error) {
 	var (
 		queries []*Query
 		lookup  = map[string]*Query{}
 	)
 
-	for _, row := range rows {
+	metadata, err := readCSVRows(csvPath, func(row *CSVRow) error {
 		query := lookup[row.Query]
 		if query == nil {
 			query = &Query{Name: row.Query}
 			lookup[row.Query] = query
 			queries = append(queries, query)
 		}
-		query.Seconds = append(query.Seconds, row.Seconds)
+		query.SQLHash = row.SQLHash
+		query.AddSample(row.Iteration, measurement{
+			Duration:  time.Duration(row.Seconds * float64(time.Second)),
+			Rows:      row.Rows,
+			Planning:  -1,
+			Execution: -1,
+			FirstRow:  -1,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
 	for _, query := range queries {
 		query.UpdateStats()
 	}
-	return queries, nil
+	return queries, metadata, nil
+}
+
+// warnMetadataDiff prints a warning for every setting affecting the
+// measurements that differs between the baseline and current metadata.
+// Settings missing from either are ignored, e.g. for older baselines.
+func warnMetadataDiff(baseline, current map[string]string) {
+	for _, key := range []string{"method", "planning"} {
+		b, bok := baseline[key]
+		c, cok := current[key]
+		if bok && cok && b != c {
+			fmt.Fprintf(os.Stderr, "Warning: the baseline was measured with %s %s, but the current run uses %s\n", key, b, c)
+		}
+	}
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// pairedResult is the result of comparing paired samples, see -paired.
+type pairedResult struct {
+	// N is the number of pairs.
+	N int
+	// MeanDiff is the mean of the per-pair differences, and CILow and CIHigh
+	// are the bounds of its 95% confidence interval.
+	MeanDiff, CILow, CIHigh float64
+	// P is the two-sided p-value of the paired t-test for a mean difference
+	// of zero.
+	P float64
+}
+
+// Summary returns a summary of r for the difference b - a, assuming the
+// differences are given in seconds.
+func (r pairedResult) Summary(a, b string) string {
+	return fmt.Sprintf(
+		"paired difference %s - %s over %d iterations: mean %+.3fms (95%% CI %+.3fms to %+.3fms), p=%.4f (paired t-test)",
+		b, a, r.N, r.MeanDiff*1000, r.CILow*1000, r.CIHigh*1000, r.P,
+	)
+}
+
+// pairedTTest compares the pairs of samples a[i] and b[i] via the
+// differences b[i] - a[i].
+func pairedTTest(a, b []float64) (pairedResult, error) {
+	if len(a) != len(b) {
+		return pairedResult{}, fmt.Errorf("unequal number of samples: %d != %d", len(a), len(b))
+	} else if len(a) < 2 {
+		return pairedResult{}, errors.New("at least 2 pairs are required")
+	}
+
+	n := float64(len(a))
+	var sum float64
+	for i := range a {
+		sum += b[i] - a[i]
+	}
+	mean := sum / n
+	var squares float64
+	for i := range a {
+		d := b[i] - a[i] - mean
+		squares += d * d
+	}
+	se := math.Sqrt(squares/(n-1)) / math.Sqrt(n)
+
+	r := pairedResult{N: len(a), MeanDiff: mean, CILow: mean, CIHigh: mean, P: 1}
+	if se == 0 {
+		if mean != 0 {
+			r.P = 0
+		}
+		return r, nil
+	}
+	df := n - 1
+	r.P = studentTTwoSided(mean/se, df)
+	margin := studentTCritical(0.05, df) * se
+	r.CILow, r.CIHigh = mean-margin, mean+margin
+	return r, nil
+}
+
+// studentTTwoSided returns the probability of a Student's t-distributed value
+// with df degrees of freedom being at least as extreme as t.
+func studentTTwoSided(t, df float64) float64 {
+	return regIncBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// studentTCritical returns the t value whose two-sided probability with df
+// degrees of freedom is alpha, e.g. 2.228 for alpha=0.05 and df=10.
+func studentTCritical(alpha, df float64) float64 {
+	lo, hi := 0.0, 1.0
+	for studentTTwoSided(hi, df) > alpha {
+		hi *= 2
+	}
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if studentTTwoSided(mid, df) > alpha {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// regIncBeta returns the regularized incomplete beta function I_x(a, b),
+// see Numerical Recipes, section 6.4.
+func regIncBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	} else if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(x, a, b) / a
+	}
+	return 1 - front*betaContinuedFraction(1-x, b, a)/b
+}
+
+// betaContinuedFraction evaluates the continued fraction used by regIncBeta
+// via the modified Lentz's method.
+func betaContinuedFraction(x, a, b float64) float64 {
+	const (
+		maxIterations = 300
+		epsilon       = 1e-15
+		tiny          = 1e-300
+	)
+	clamp := func(v float64) float64 {
+		if math.Abs(v) < tiny {
+			return tiny
+		}
+		return v
+	}
+	c, d := 1.0, 1/clamp(1-(a+b)*x/(a+1))
+	h := d
+	for m := 1.0; m <= maxIterations; m++ {
+		even := m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		d = 1 / clamp(1+even*d)
+		c = clamp(1 + even/c)
+		h *= d * c
+
+		odd := -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+		d = 1 / clamp(1+odd*d)
+		c = clamp(1 + odd/c)
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
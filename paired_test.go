@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_studentTCritical(t *testing.T) {
+	// Values from a t-table for alpha=0.05, two-sided.
+	tests := []struct {
+		DF   float64
+		Want float64
+	}{
+		{1, 12.706},
+		{5, 2.571},
+		{10, 2.228},
+		{100, 1.984},
+	}
+	for _, test := range tests {
+		if got := studentTCritical(0.05, test.DF); math.Abs(got-test.Want) > 0.001 {
+			t.Errorf("df=%g: got=%.4f want=%.3f", test.DF, got, test.Want)
+		}
+	}
+}
+
+func Test_pairedTTest(t *testing.T) {
+	a := []float64{10, 12, 11, 14, 13}
+	b := []float64{11, 14, 11, 16, 14}
+	r, err := pairedTTest(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Differences are 1, 2, 0, 2, 1: mean 1.2, sd 0.8367, t=3.207 with df=4.
+	if r.N != 5 || math.Abs(r.MeanDiff-1.2) > 1e-9 {
+		t.Fatalf("unexpected result: %+v", r)
+	} else if math.Abs(r.P-0.0327) > 0.0005 {
+		t.Errorf("got p=%.4f want=0.0327", r.P)
+	} else if math.Abs(r.CILow-0.161) > 0.001 || math.Abs(r.CIHigh-2.239) > 0.001 {
+		t.Errorf("got ci=[%.3f, %.3f] want=[0.161, 2.239]", r.CILow, r.CIHigh)
+	}
+
+	if _, err := pairedTTest([]float64{1}, []float64{2}); err == nil {
+		t.Error("expected error for a single pair")
+	}
+}
@@ -4,17 +4,89 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os/exec"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-type queryDurationFunc = func(context.Context, *sql.Conn, string, bool) func() (time.Duration, error)
+// queryDurationFunc prepares the given query for being measured and returns a
+// function that executes it once and returns the resulting measurement.
+type queryDurationFunc = func(context.Context, *sql.Conn, string, queryDurationOptions) func(context.Context) (measurement, error)
+
+// measurement holds the result of executing a query once.
+type measurement struct {
+	// Duration is the measured query time.
+	Duration time.Duration
+	// Rows is the number of rows returned by the top plan node. Only available
+	// for -m explain, otherwise -1.
+	Rows float64
+	// Plan is the executed query plan. Only available for -m explain.
+	Plan *explainPlan
+	// Planning and Execution are the planning and execution times reported
+	// by PostgreSQL. Only available for -m explain, otherwise -1.
+	Planning  time.Duration
+	Execution time.Duration
+	// FirstRow is the time until the first row was received, or Duration if
+	// the query returned no rows. Only available for -m client, otherwise -1.
+	FirstRow time.Duration
+}
+
+// queryDurationOptions holds the options that are passed to all
+// queryDurationFuncs.
+type queryDurationOptions struct {
+	// IncludePlanning causes the planning time to be included in the
+	// measurement, see -p.
+	IncludePlanning bool
+	// SimpleProtocol indicates that the connection doesn't support prepared
+	// statements, see -pgbouncer.
+	SimpleProtocol bool
+	// GenericPlan causes -m explain to measure the query via an explicitly
+	// prepared statement, so the plan_cache_mode setting applies to it, see
+	// -generic-plan.
+	GenericPlan bool
+	// TimerOverhead is subtracted from client wallclock measurements, see
+	// -timer-overhead.
+	TimerOverhead time.Duration
+	// Buffers causes -m explain to include buffer usage in the plan, see
+	// -explain-buffers-summary.
+	Buffers bool
+	// Verbose causes -m explain to include the output columns of every node
+	// in the plan, see -explain-verbose.
+	Verbose bool
+	// RotateParams causes -m prepare and -m explain with GenericPlan to
+	// rotate through all "-- params:" directives of the query on successive
+	// executions, see -rotate-params.
+	RotateParams bool
+	// BatchSize is the number of executions per measurement of -m batch, see
+	// -batch-size.
+	BatchSize int
+	// ExecCmd is the shell command that -m exec measures the query with, see
+	// -exec-cmd.
+	ExecCmd string
+	// Explained causes -explain-once to skip the EXPLAIN, because the query
+	// already has a plan, e.g. from a duration func built for a previous
+	// connection.
+	Explained bool
+	// Transient indicates that the query is only executed once, e.g. because
+	// it was rendered from a template. No prepared statements are left behind
+	// in this case, which means that -m client includes the planning time.
+	Transient bool
+}
 
 var queryDurationFuncs = map[string]queryDurationFunc{
+	"batch":   batchDuration,
 	"client":  clientDuration,
+	"exec":    execDuration,
 	"explain": explainDuration,
+	"multi":   multiDuration,
+	"prepare": prepareDuration,
+	"server":  serverDuration,
 }
 
 var queryDurationMethods = func() string {
@@ -26,65 +98,529 @@ var queryDurationMethods = func() string {
 	return strings.Join(list, ", ")
 }
 
-func clientDuration(ctx context.Context, conn *sql.Conn, query string, includePlanning bool) func() (time.Duration, error) {
+// clientDuration measures the client wallclock time of executing the query
+// and reading all of its rows. CALL statements are executed without reading a
+// result set.
+func clientDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
 	var (
 		queryContext func(context.Context, ...interface{}) (*sql.Rows, error)
+		execContext  func(context.Context, ...interface{}) (sql.Result, error)
 		prepareErr   error
 	)
 
-	if !includePlanning {
+	// Prepared statements are unavailable with the simple protocol, so the
+	// planning time is always included in this case.
+	if !opts.IncludePlanning && !opts.SimpleProtocol && !opts.Transient {
 		stmt, err := conn.PrepareContext(ctx, query)
 		if err != nil {
 			prepareErr = err
 		} else {
-			queryContext = stmt.QueryContext
+			queryContext, execContext = stmt.QueryContext, stmt.ExecContext
 		}
 	} else {
 		queryContext = func(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
 			return conn.QueryContext(ctx, query, args...)
 		}
+		execContext = func(ctx context.Context, args ...interface{}) (sql.Result, error) {
+			return conn.ExecContext(ctx, query, args...)
+		}
 	}
+	call := isCallStatement(query)
 
-	return func() (time.Duration, error) {
+	return func(ctx context.Context) (measurement, error) {
 		if prepareErr != nil {
-			return 0, prepareErr
+			return measurement{}, prepareErr
 		}
 
 		start := time.Now()
+		if call {
+			if _, err := execContext(ctx); err != nil {
+				return measurement{}, err
+			}
+			return measurement{Duration: subtractOverhead(time.Since(start), opts.TimerOverhead), Rows: -1, Planning: -1, Execution: -1, FirstRow: -1}, nil
+		}
 		rows, err := queryContext(ctx)
 		if err != nil {
-			return 0, err
+			return measurement{}, err
 		}
 		defer rows.Close()
+		firstRow := time.Duration(-1)
 		for rows.Next() {
-			// do nothing
+			if firstRow < 0 {
+				firstRow = time.Since(start)
+			}
 		}
 		if err := rows.Err(); err != nil {
-			return 0, err
+			return measurement{}, err
 		} else if err := rows.Close(); err != nil {
-			return 0, err
+			return measurement{}, err
+		}
+		duration := time.Since(start)
+		if firstRow < 0 {
+			firstRow = duration
+		}
+		return measurement{
+			Duration:  subtractOverhead(duration, opts.TimerOverhead),
+			Rows:      -1,
+			Planning:  -1,
+			Execution: -1,
+			FirstRow:  subtractOverhead(firstRow, opts.TimerOverhead),
+		}, nil
+	}
+}
+
+// multiDuration measures the client wallclock time for executing all
+// statements of the query as a single batch using the simple query protocol.
+// This allows measuring multi-statement transactions such as
+// "BEGIN; UPDATE ...; SELECT ...; COMMIT;". Since no prepared statements are
+// used, the planning time is always included.
+func multiDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
+	return func(ctx context.Context) (measurement, error) {
+		start := time.Now()
+		// Without arguments pgx uses the simple protocol which supports
+		// multiple statements.
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return measurement{}, err
+		}
+		return measurement{Duration: subtractOverhead(time.Since(start), opts.TimerOverhead), Rows: -1, Planning: -1, Execution: -1, FirstRow: -1}, nil
+	}
+}
+
+// explainOnceDuration measures the query like clientDuration, but runs it via
+// explainDuration once before the first measurement unless opts.Explained is
+// true, see -explain-once. The
+// plan, planning and execution time of the EXPLAIN are attached to the first
+// measurement, while its duration is the client time like for all others, so
+// the instrumentation overhead doesn't skew the latency distribution.
+func explainOnceDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
+	var (
+		client    = clientDuration(ctx, conn, query, opts)
+		explain   = explainDuration(ctx, conn, query, opts)
+		explained = opts.Explained
+	)
+	return func(ctx context.Context) (measurement, error) {
+		var e measurement
+		if !explained {
+			var err error
+			if e, err = explain(ctx); err != nil {
+				return measurement{}, err
+			}
+			explained = true
+		}
+		m, err := client(ctx)
+		if err != nil {
+			return measurement{}, err
+		}
+		if e.Plan != nil {
+			m.Plan, m.Planning, m.Execution = e.Plan, e.Planning, e.Execution
+		}
+		return m, nil
+	}
+}
+
+// batchDuration measures the client wallclock time of executing the query
+// opts.BatchSize times in a loop inside a single DO block, and divides it by
+// the batch size. This amortizes the round trip over many executions, which
+// makes it more accurate than the other methods for queries that only take
+// microseconds. PL/pgSQL caches the plan of the query after the first
+// executions, so the planning time is mostly excluded.
+func batchDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
+	stmt := batchStatement(query, opts.BatchSize)
+	return func(ctx context.Context) (measurement, error) {
+		start := time.Now()
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return measurement{}, err
+		}
+		d := subtractOverhead(time.Since(start), opts.TimerOverhead) / time.Duration(opts.BatchSize)
+		return measurement{Duration: d, Rows: -1, Planning: -1, Execution: -1, FirstRow: -1}, nil
+	}
+}
+
+// batchStatement returns the DO block executing query size times, see
+// batchDuration. Queries returning rows are executed via PERFORM, since
+// PL/pgSQL doesn't allow discarding their rows otherwise.
+func batchStatement(query string, size int) string {
+	stmt := strings.TrimRight(strings.TrimSpace(query), ";")
+	fields := strings.Fields(stripSQLComments(query))
+	if len(fields) > 0 && contains([]string{"SELECT", "WITH", "VALUES", "TABLE"}, strings.ToUpper(fields[0])) {
+		stmt = "PERFORM * FROM (\n" + stmt + "\n) sqlbench_batch"
+	}
+	return fmt.Sprintf("DO $sqlbench$ BEGIN FOR i IN 1..%d LOOP\n%s;\nEND LOOP; END $sqlbench$", size, stmt)
+}
+
+var (
+	// returningPattern and dataModifyingPattern are used by batchable.
+	returningPattern     = regexp.MustCompile(`(?i)\bRETURNING\b`)
+	dataModifyingPattern = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE)\b`)
+)
+
+// batchable returns false if query can't be executed by batchStatement. This
+// is the case for data-modifying statements with a RETURNING clause, whose
+// rows PL/pgSQL can't discard, and for WITH queries containing data-modifying
+// statements, which can't be used with PERFORM.
+func batchable(query string) bool {
+	sql := stripSQLComments(query)
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return true
+	} else if strings.EqualFold(fields[0], "WITH") && dataModifyingPattern.MatchString(sql) {
+		return false
+	}
+	return !returningPattern.MatchString(sql)
+}
+
+// execDuration measures the query by running opts.ExecCmd via "sh -c" with the
+// query on stdin. The command has to print the duration in seconds on stdout,
+// which allows measuring backends or methods sqlbench doesn't support itself.
+// The connection is not used.
+func execDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
+	return func(ctx context.Context) (measurement, error) {
+		cmd := exec.CommandContext(ctx, "sh", "-c", opts.ExecCmd)
+		cmd.Stdin = strings.NewReader(query)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return measurement{}, fmt.Errorf("-exec-cmd: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		d, err := parseExecDuration(string(out))
+		if err != nil {
+			return measurement{}, fmt.Errorf("-exec-cmd: %w", err)
+		}
+		return measurement{Duration: d, Rows: -1, Planning: -1, Execution: -1, FirstRow: -1}, nil
+	}
+}
+
+// parseExecDuration parses the output of an -exec-cmd.
+func parseExecDuration(out string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("bad output: %q: must be a duration in seconds >= 0", out)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// workloadDuration returns a queryDurationFunc that measures the steps one
+// after another with fn and reports their total as a single measurement, see
+// -workload. The query passed to it is ignored in favor of the steps. Rows,
+// Planning and Execution are only summed up if fn reports them.
+func workloadDuration(fn queryDurationFunc, steps []string) queryDurationFunc {
+	return func(ctx context.Context, conn *sql.Conn, _ string, opts queryDurationOptions) func(context.Context) (measurement, error) {
+		var stepFns []func(context.Context) (measurement, error)
+		for _, step := range steps {
+			stepFns = append(stepFns, fn(ctx, conn, step, opts))
+		}
+		return func(ctx context.Context) (measurement, error) {
+			var total measurement
+			for i, stepFn := range stepFns {
+				m, err := stepFn(ctx)
+				if err != nil {
+					return measurement{}, err
+				}
+				total.Duration += m.Duration
+				if i == 0 || total.Rows >= 0 && m.Rows >= 0 {
+					total.Rows += m.Rows
+				} else {
+					total.Rows = -1
+				}
+				if i == 0 || total.Planning >= 0 && m.Planning >= 0 {
+					total.Planning += m.Planning
+				} else {
+					total.Planning = -1
+				}
+				if i == 0 || total.Execution >= 0 && m.Execution >= 0 {
+					total.Execution += m.Execution
+				} else {
+					total.Execution = -1
+				}
+			}
+			total.FirstRow = -1
+			return total, nil
+		}
+	}
+}
+
+// prepareDuration measures the client wallclock time of executing the query
+// via an explicit PREPARE and EXECUTE, which is how some client libraries
+// use prepared statements. Parameters for the EXECUTE can be given via a
+// "-- params: 1, 'foo'" comment in the query file. If opts.IncludePlanning is
+// true, the statement is prepared as part of every measurement, and
+// deallocated afterwards.
+func prepareDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
+	// Every measured query gets its own statement, even if the SQL is the
+	// same as for another one, e.g. for the single and rotated params copy.
+	name := nextStatementName()
+	prepareSQL, executeSQL := prepareStatements(name, query)
+	executes := []string{executeSQL}
+	if opts.RotateParams {
+		executes = executeStatements(name, query)
+	}
+	var executions int
+
+	prepare := func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, prepareSQL)
+		return err
+	}
+
+	deallocate := func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, "DEALLOCATE "+name)
+		return err
+	}
+
+	var prepareErr error
+	if !opts.IncludePlanning && !opts.Transient {
+		prepareErr = prepare(ctx)
+	}
+
+	return func(ctx context.Context) (_ measurement, err error) {
+		if prepareErr != nil {
+			return measurement{}, prepareErr
+		}
+		if opts.Transient && !opts.IncludePlanning {
+			if err := prepare(ctx); err != nil {
+				return measurement{}, err
+			}
+			defer deallocate(ctx)
+		}
+
+		executeSQL := executes[executions%len(executes)]
+		executions++
+
+		start := time.Now()
+		if opts.IncludePlanning {
+			if err := prepare(ctx); err != nil {
+				return measurement{}, err
+			}
+			// Deferred, so failed executions don't leak the statement.
+			defer func() {
+				if deallocateErr := deallocate(ctx); err == nil {
+					err = deallocateErr
+				}
+			}()
+		}
+		if _, err := conn.ExecContext(ctx, executeSQL); err != nil {
+			return measurement{}, err
+		}
+		d := subtractOverhead(time.Since(start), opts.TimerOverhead)
+		return measurement{Duration: d, Rows: -1, Planning: -1, Execution: -1, FirstRow: -1}, nil
+	}
+}
+
+// prepareStatements returns the PREPARE and EXECUTE statements for running
+// query as a prepared statement with the given name. Parameters for the
+// EXECUTE are taken from the first "-- params:" directive of the query.
+func prepareStatements(name, query string) (prepareSQL, executeSQL string) {
+	prepareSQL = fmt.Sprintf("PREPARE %s AS %s", name, strings.TrimRight(strings.TrimSpace(query), ";"))
+	return prepareSQL, executeStatements(name, query)[0]
+}
+
+// executeStatements returns an EXECUTE statement of the prepared statement
+// with the given name for every "-- params:" directive of query, or a single
+// one without parameters if there are none.
+func executeStatements(name, query string) []string {
+	paramSets := queryDirectives(query, "params")
+	if len(paramSets) == 0 {
+		return []string{"EXECUTE " + name}
+	}
+	var statements []string
+	for _, params := range paramSets {
+		statements = append(statements, "EXECUTE "+name+"("+params+")")
+	}
+	return statements
+}
+
+// measuredStatements returns the statements executed for measuring query
+// with the given method, including the PREPARE and DEALLOCATE of prepared
+// statements and the statements querying the measurements. It's used for
+// showing what exactly is being measured via -v. Statement names that are
+// only determined at runtime are shown as sqlbench_<n>.
+func measuredStatements(method, query string, opts queryDurationOptions) []string {
+	const name = "sqlbench_<n>"
+	switch method {
+	case "explain":
+		if opts.GenericPlan {
+			prepareSQL, executeSQL := prepareStatements(name, query)
+			statements := []string{prepareSQL, explainStatement(executeSQL, opts)}
+			if opts.Transient {
+				statements = append(statements, "DEALLOCATE "+name)
+			}
+			return statements
+		}
+		return []string{explainStatement(query, opts)}
+	case "prepare":
+		prepareSQL, executeSQL := prepareStatements(name, query)
+		statements := []string{prepareSQL, executeSQL}
+		if opts.IncludePlanning || opts.Transient {
+			statements = append(statements, "DEALLOCATE "+name)
+		}
+		return statements
+	case "server":
+		// The query identifier is determined once via EXPLAIN, and the
+		// statistics are queried before and after every execution.
+		return []string{serverExplainStatement(query), serverStatsQuery, query, serverStatsQuery}
+	case "batch":
+		return []string{batchStatement(query, opts.BatchSize)}
+	default:
+		return []string{query}
+	}
+}
+
+// serverStatsQuery queries the total execution and planning time that
+// pg_stat_statements recorded for the query identifier $1, see serverDuration.
+const serverStatsQuery = `
+SELECT coalesce(sum(total_exec_time), 0), coalesce(sum(total_plan_time), 0)
+FROM pg_stat_statements
+WHERE queryid = $1 AND dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+`
+
+// serverExplainStatement returns the statement serverDuration uses for
+// determining the query identifier of query.
+func serverExplainStatement(query string) string {
+	return "EXPLAIN (VERBOSE, FORMAT JSON) " + query
+}
+
+// serverDuration measures the execution time reported by the
+// pg_stat_statements extension. Unlike explainDuration this doesn't add any
+// instrumentation overhead, and unlike clientDuration it excludes the network
+// round trip. It requires PostgreSQL 14+ with pg_stat_statements installed.
+// The planning time is only included with pg_stat_statements.track_planning
+// enabled.
+func serverDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
+	executeFn := clientDuration(ctx, conn, query, opts)
+
+	var queryID int64
+	setupErr := func() error {
+		var explainJSON []byte
+		if err := conn.QueryRowContext(ctx, serverExplainStatement(query)).Scan(&explainJSON); err != nil {
+			return err
+		}
+		var explain []struct {
+			QueryIdentifier int64 `json:"Query Identifier"`
+		}
+		if err := json.Unmarshal(explainJSON, &explain); err != nil {
+			return err
+		} else if len(explain) != 1 {
+			return fmt.Errorf("bad json: %q", explainJSON)
+		} else if explain[0].QueryIdentifier == 0 {
+			return errors.New("-m server: no query identifier available: requires PostgreSQL 14+ with pg_stat_statements")
+		}
+		queryID = explain[0].QueryIdentifier
+		return nil
+	}()
+
+	snapshot := func(ctx context.Context) (exec, plan float64, err error) {
+		err = conn.QueryRowContext(ctx, serverStatsQuery, queryID).Scan(&exec, &plan)
+		return
+	}
+
+	return func(ctx context.Context) (measurement, error) {
+		if setupErr != nil {
+			return measurement{}, setupErr
+		}
+
+		execBefore, planBefore, err := snapshot(ctx)
+		if err != nil {
+			return measurement{}, err
+		} else if _, err := executeFn(ctx); err != nil {
+			return measurement{}, err
+		}
+		execAfter, planAfter, err := snapshot(ctx)
+		if err != nil {
+			return measurement{}, err
+		}
+
+		totalTime := execAfter - execBefore
+		if opts.IncludePlanning {
+			totalTime += planAfter - planBefore
 		}
-		return time.Since(start), nil
+		d := time.Duration(float64(time.Millisecond) * totalTime)
+		return measurement{Duration: d, Rows: -1, Planning: -1, Execution: -1, FirstRow: -1}, nil
+	}
+}
+
+// calibrateTimerOverhead returns the average time it takes to measure an
+// empty operation using time.Now() and time.Since().
+func calibrateTimerOverhead() time.Duration {
+	const rounds = 100000
+	var total time.Duration
+	for i := 0; i < rounds; i++ {
+		start := time.Now()
+		total += time.Since(start)
+	}
+	return total / rounds
+}
+
+// subtractOverhead returns d minus overhead, but never less than 0.
+func subtractOverhead(d, overhead time.Duration) time.Duration {
+	if d < overhead {
+		return 0
 	}
+	return d - overhead
 }
 
-func explainDuration(ctx context.Context, conn *sql.Conn, query string, includePlanning bool) func() (time.Duration, error) {
+func explainDuration(ctx context.Context, conn *sql.Conn, query string, opts queryDurationOptions) func(context.Context) (measurement, error) {
 	type explainQuery struct {
+		Plan          *explainPlan
 		ExecutionTime float64 `json:"Execution Time"`
 		PlanningTime  float64 `json:"Planning Time"`
 	}
 
-	query = "EXPLAIN (ANALYZE, FORMAT JSON, TIMING OFF) " + query
-	return func() (time.Duration, error) {
+	var (
+		prepareErr error
+		// transientPrepare prepares a transient statement and returns a
+		// function for deallocating it.
+		transientPrepare func(context.Context) (func(), error)
+		// statements are executed in turn, see opts.RotateParams.
+		statements = []string{query}
+		executions int
+	)
+	if opts.GenericPlan {
+		// EXPLAIN (GENERIC_PLAN) can't be combined with ANALYZE, so we need to
+		// explain the execution of a prepared statement instead.
+		name := nextStatementName()
+		prepareSQL, executeSQL := prepareStatements(name, query)
+		if opts.Transient {
+			transientPrepare = func(ctx context.Context) (func(), error) {
+				if _, err := conn.ExecContext(ctx, prepareSQL); err != nil {
+					return nil, err
+				}
+				return func() { conn.ExecContext(ctx, "DEALLOCATE "+name) }, nil
+			}
+		} else if _, err := conn.ExecContext(ctx, prepareSQL); err != nil {
+			prepareErr = err
+		}
+		statements = []string{executeSQL}
+		if opts.RotateParams {
+			statements = executeStatements(name, query)
+		}
+	}
+
+	for i, statement := range statements {
+		statements[i] = explainStatement(statement, opts)
+	}
+	return func(ctx context.Context) (measurement, error) {
+		if prepareErr != nil {
+			return measurement{}, prepareErr
+		}
+		if transientPrepare != nil {
+			deallocate, err := transientPrepare(ctx)
+			if err != nil {
+				return measurement{}, err
+			}
+			defer deallocate()
+		}
+
+		query := statements[executions%len(statements)]
+		executions++
 		var explainJSON []byte
 		if err := conn.QueryRowContext(ctx, query).Scan(&explainJSON); err != nil {
-			return 0, err
+			return measurement{}, err
 		}
 		var queries []explainQuery
 		if err := json.Unmarshal(explainJSON, &queries); err != nil {
-			return 0, err
-		} else if len(queries) != 1 {
-			return 0, fmt.Errorf("bad json: %q", explainJSON)
+			return measurement{}, err
+		} else if len(queries) != 1 || queries[0].Plan == nil {
+			return measurement{}, fmt.Errorf("bad json: %q", explainJSON)
 		}
 
 		executionTime := queries[0].ExecutionTime
@@ -92,26 +628,56 @@ func explainDuration(ctx context.Context, conn *sql.Conn, query string, includeP
 
 		// See negativeTimeError comment for more details.
 		if executionTime < 0 {
-			return 0, negativeTimeError{"Execution", executionTime}
+			return measurement{}, negativeTimeError{"Execution", executionTime}
 		} else if planningTime < 0 {
-			return 0, negativeTimeError{"Planning", planningTime}
+			return measurement{}, negativeTimeError{"Planning", planningTime}
 		}
 
 		totalTime := executionTime
-		if includePlanning {
+		if opts.IncludePlanning {
 			totalTime += planningTime
 		}
 
 		d := time.Duration(float64(time.Millisecond) * totalTime)
-		return d, nil
+		plan := queries[0].Plan
+		return measurement{
+			Duration:  d,
+			Rows:      plan.ActualRows,
+			Plan:      plan,
+			Planning:  time.Duration(float64(time.Millisecond) * planningTime),
+			Execution: time.Duration(float64(time.Millisecond) * executionTime),
+			FirstRow:  -1,
+		}, nil
+	}
+}
+
+// explainStatement returns the EXPLAIN statement used by -m explain for
+// measuring query.
+func explainStatement(query string, opts queryDurationOptions) string {
+	options := []string{"ANALYZE"}
+	if opts.Buffers {
+		options = append(options, "BUFFERS")
 	}
+	if opts.Verbose {
+		options = append(options, "VERBOSE")
+	}
+	options = append(options, "FORMAT JSON", "TIMING OFF")
+	return "EXPLAIN (" + strings.Join(options, ", ") + ") " + query
+}
+
+// statementCounter is used by nextStatementName.
+var statementCounter int64
+
+// nextStatementName returns a unique name for a prepared statement.
+func nextStatementName() string {
+	return fmt.Sprintf("sqlbench_%d", atomic.AddInt64(&statementCounter, 1))
 }
 
 // negativeTimeError indicates that a negative execution/planning time was
 // reported by PostgreSQL. This is something I encounter with Docker for Mac
 // sometimes, which is known to be very buggy [1] when it comes to time
 // handling. This error message allows sqlbench to simply retry when this issue
-// is encountered.
+// is encountered, up to -max-negative-retries times.
 // [1] https://twitter.com/felixge/status/1221512507690496001
 type negativeTimeError struct {
 	Type string
@@ -1,30 +1,202 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_queryDurationFuncs(t *testing.T) {
 	ctx, conn, cleanup := setup(t)
 	defer cleanup()
 
+	var hasStatStatements bool
+	if err := conn.QueryRowContext(ctx, "SELECT count(*) > 0 FROM pg_extension WHERE extname = 'pg_stat_statements'").Scan(&hasStatStatements); err != nil {
+		t.Fatal(err)
+	}
+
+	// base holds the options required by some of the methods.
+	base := queryDurationOptions{BatchSize: 10, ExecCmd: "echo 0.001"}
 	for name, fn := range queryDurationFuncs {
+		if name == "server" && !hasStatStatements {
+			t.Logf("skipping %s: pg_stat_statements is not installed", name)
+			continue
+		}
+
 		t.Run(name+" with planning", func(t *testing.T) {
-			d, err := fn(ctx, conn, "SELECT 1", true)()
+			opts := base
+			opts.IncludePlanning = true
+			m, err := fn(ctx, conn, "SELECT 1", opts)(ctx)
 			if err != nil {
 				t.Fatal(err)
-			} else if d <= 0 {
-				t.Fatalf("bad duration: %s", d)
+			} else if m.Duration <= 0 {
+				t.Fatalf("bad duration: %s", m.Duration)
 			}
 		})
 
 		t.Run(name+" without planning", func(t *testing.T) {
-			d, err := fn(ctx, conn, "SELECT 1", false)()
+			m, err := fn(ctx, conn, "SELECT 1", base)(ctx)
 			if err != nil {
 				t.Fatal(err)
-			} else if d <= 0 {
-				t.Fatalf("bad duration: %s", d)
+			} else if m.Duration <= 0 {
+				t.Fatalf("bad duration: %s", m.Duration)
 			}
 		})
 	}
+
+	t.Run("prepare same sql twice", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if _, err := prepareDuration(ctx, conn, "SELECT 1", base)(ctx); err != nil {
+				t.Fatalf("query %d: %s", i, err)
+			}
+		}
+	})
+
+	t.Run("prepare prepared vs unprepared", func(t *testing.T) {
+		unpreparedOpts := base
+		unpreparedOpts.IncludePlanning = true
+		prepared := prepareDuration(ctx, conn, "SELECT 1", base)
+		unprepared := prepareDuration(ctx, conn, "SELECT 1", unpreparedOpts)
+		for _, fn := range []func(context.Context) (measurement, error){prepared, unprepared, prepared} {
+			if _, err := fn(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("prepare workload repeating a query", func(t *testing.T) {
+		query := prepareDuration(ctx, conn, "SELECT 1", base)
+		workload := workloadDuration(prepareDuration, []string{"SELECT 1", "SELECT 1"})(ctx, conn, "", base)
+		for _, fn := range []func(context.Context) (measurement, error){query, workload} {
+			if _, err := fn(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("prepare with planning after failure", func(t *testing.T) {
+		opts := base
+		opts.IncludePlanning = true
+		fn := prepareDuration(ctx, conn, "SELECT 1/0", opts)
+		for i := 0; i < 2; i++ {
+			// A leaked statement would fail the second PREPARE instead.
+			if _, err := fn(ctx); err == nil || !strings.Contains(err.Error(), "division by zero") {
+				t.Fatalf("execution %d: got err=%v", i+1, err)
+			}
+		}
+	})
+
+	t.Run("explain after discard all", func(t *testing.T) {
+		// Every -cold iteration builds new duration funcs after DISCARD ALL.
+		for i := 0; i < 2; i++ {
+			if err := discardAll(ctx, conn); err != nil {
+				t.Fatal(err)
+			} else if _, err := explainDuration(ctx, conn, "SELECT 1", base)(ctx); err != nil {
+				t.Fatalf("iteration %d: %s", i+1, err)
+			}
+		}
+	})
+
+	t.Run("explain once", func(t *testing.T) {
+		fn := explainOnceDuration(ctx, conn, "SELECT 1", queryDurationOptions{})
+		for i := 0; i < 2; i++ {
+			m, err := fn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			} else if explained := m.Plan != nil && m.Execution >= 0; explained != (i == 0) {
+				t.Fatalf("execution %d: got explained=%t", i, explained)
+			}
+		}
+
+		opts := queryDurationOptions{Explained: true}
+		if m, err := explainOnceDuration(ctx, conn, "SELECT 1", opts)(ctx); err != nil {
+			t.Fatal(err)
+		} else if m.Plan != nil {
+			t.Fatal("explained query was explained again")
+		}
+	})
+}
+
+func Test_measuredStatements(t *testing.T) {
+	query := "-- params: 1\nSELECT $1::int;"
+	tests := []struct {
+		method string
+		opts   queryDurationOptions
+		want   string
+	}{
+		{"client", queryDurationOptions{}, query},
+		{"explain", queryDurationOptions{}, "EXPLAIN (ANALYZE, FORMAT JSON, TIMING OFF) " + query},
+		{"explain", queryDurationOptions{Buffers: true, Verbose: true}, "EXPLAIN (ANALYZE, BUFFERS, VERBOSE, FORMAT JSON, TIMING OFF) " + query},
+		{"explain", queryDurationOptions{GenericPlan: true}, "PREPARE sqlbench_<n> AS " + strings.TrimSuffix(query, ";") + "|EXPLAIN (ANALYZE, FORMAT JSON, TIMING OFF) EXECUTE sqlbench_<n>(1)"},
+		{"batch", queryDurationOptions{BatchSize: 10}, "DO $sqlbench$ BEGIN FOR i IN 1..10 LOOP\nPERFORM * FROM (\n-- params: 1\nSELECT $1::int\n) sqlbench_batch;\nEND LOOP; END $sqlbench$"},
+		{"prepare", queryDurationOptions{}, "PREPARE sqlbench_<n> AS " + strings.TrimSuffix(query, ";") + "|EXECUTE sqlbench_<n>(1)"},
+		{"prepare", queryDurationOptions{IncludePlanning: true}, "PREPARE sqlbench_<n> AS " + strings.TrimSuffix(query, ";") + "|EXECUTE sqlbench_<n>(1)|DEALLOCATE sqlbench_<n>"},
+		{"server", queryDurationOptions{}, "EXPLAIN (VERBOSE, FORMAT JSON) " + query + "|" + serverStatsQuery + "|" + query + "|" + serverStatsQuery},
+	}
+	for _, test := range tests {
+		if got := strings.Join(measuredStatements(test.method, query, test.opts), "|"); got != test.want {
+			t.Errorf("%s %+v: got=%q want=%q", test.method, test.opts, got, test.want)
+		}
+	}
+}
+
+func Test_batchStatement(t *testing.T) {
+	got := batchStatement("UPDATE counters SET n = n + 1;", 5)
+	want := "DO $sqlbench$ BEGIN FOR i IN 1..5 LOOP\nUPDATE counters SET n = n + 1;\nEND LOOP; END $sqlbench$"
+	if got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+}
+
+func Test_batchable(t *testing.T) {
+	tests := map[string]bool{
+		"SELECT 1":                                                     true,
+		"-- RETURNING\nUPDATE t SET n = 1":                             true,
+		"WITH x AS (SELECT 1) TABLE x":                                 true,
+		"INSERT INTO t VALUES (1) RETURNING id":                        false,
+		"WITH d AS (DELETE FROM t RETURNING *) SELECT count(*) FROM d": false,
+		"WITH d AS (DELETE FROM t) SELECT 1":                           false,
+	}
+	for query, want := range tests {
+		if got := batchable(query); got != want {
+			t.Errorf("%q: got=%t want=%t", query, got, want)
+		}
+	}
+}
+
+func Test_execDuration(t *testing.T) {
+	opts := queryDurationOptions{ExecCmd: `test "$(cat)" = "SELECT 1" && echo 0.25`}
+	m, err := execDuration(context.Background(), nil, "SELECT 1", opts)(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if m.Duration != 250*time.Millisecond || m.Rows != -1 {
+		t.Fatalf("unexpected measurement: %+v", m)
+	}
+
+	for _, cmd := range []string{"echo fast", "echo -1", "echo 1; exit 1"} {
+		opts := queryDurationOptions{ExecCmd: cmd}
+		if _, err := execDuration(context.Background(), nil, "SELECT 1", opts)(context.Background()); err == nil {
+			t.Errorf("%s: expected error", cmd)
+		}
+	}
+}
+
+func Test_workloadDuration(t *testing.T) {
+	var executed []string
+	fn := func(_ context.Context, _ *sql.Conn, query string, _ queryDurationOptions) func(context.Context) (measurement, error) {
+		return func(context.Context) (measurement, error) {
+			executed = append(executed, query)
+			return measurement{Duration: time.Millisecond, Rows: 2, Planning: -1, Execution: -1, FirstRow: time.Microsecond}, nil
+		}
+	}
+	m, err := workloadDuration(fn, []string{"a", "b", "c"})(context.Background(), nil, "ignored", queryDurationOptions{})(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.Join(executed, ","), "a,b,c"; got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	} else if m.Duration != 3*time.Millisecond || m.Rows != 6 || m.Planning != -1 || m.Execution != -1 || m.FirstRow != -1 {
+		t.Fatalf("unexpected measurement: %+v", m)
+	}
 }
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"golang.org/x/term"
+)
+
+// renderOptions controls how render presents the benchmark results.
+type renderOptions struct {
+	// Clear causes the terminal screen to be cleared before rendering.
+	Clear bool
+	// Baseline holds the queries loaded via -i, if any.
+	Baseline []*Query
+	// MaxNameWidth is the maximum width of query names, 0 means auto.
+	MaxNameWidth int
+	// Layout is one of the keys in tableLayouts.
+	Layout string
+	// BaselineQuery is the name of the query that all other queries are
+	// compared against, see -baseline-query.
+	BaselineQuery string
+	// Stats are the stats to display, defaults to all tableStats.
+	Stats []tableStat
+	// Matrix causes a matrix of the pairwise mean ratios between all queries
+	// to be displayed below the table, see -matrix.
+	Matrix bool
+	// AbsDelta causes duration ratios to be annotated with the absolute
+	// difference to the reference query as well, see -abs-delta.
+	AbsDelta bool
+	// Unit is one of the keys in timeUnits or unitAuto, see -unit. Empty
+	// means milliseconds.
+	Unit string
+	// CompareStat is the stat that queries are compared by, see
+	// -compare-stat. If set, the per-stat ratios are replaced by a single
+	// ratio of this stat. nil means that the matrix and summary compare the
+	// means, and every stat is annotated with its own ratio.
+	CompareStat *tableStat
+	// BaselineLabel and CurrentLabel name the -i baseline and the current
+	// results, see -baseline-label and -current-label. Empty means unnamed.
+	BaselineLabel string
+	CurrentLabel  string
+}
+
+// queryLabel returns the displayed name of the current query with the given
+// name, which is annotated with the CurrentLabel when comparing against a
+// baseline.
+func (o renderOptions) queryLabel(name string) string {
+	if len(o.Baseline) > 0 && o.CurrentLabel != "" {
+		return name + " [" + o.CurrentLabel + "]"
+	}
+	return name
+}
+
+// baselineName returns how the -i baseline is referred to in the output.
+func (o renderOptions) baselineName() string {
+	if o.BaselineLabel != "" {
+		return o.BaselineLabel
+	}
+	return "the baseline"
+}
+
+// currentName returns how the current results are referred to in the output.
+func (o renderOptions) currentName() string {
+	if o.CurrentLabel != "" {
+		return o.CurrentLabel
+	}
+	return "the current results"
+}
+
+// compareStat returns the name and value of the stat that the matrix and the
+// suite summary compare queries by.
+func (o renderOptions) compareStat() (string, func(*Query) float64) {
+	if o.CompareStat != nil {
+		return o.CompareStat.Name, o.CompareStat.Value
+	}
+	return "mean", func(q *Query) float64 { return q.Mean }
+}
+
+// timeUnit is a unit for displaying durations.
+type timeUnit struct {
+	// Name is the short name of the unit, e.g. "ms".
+	Name string
+	// Long is the long name of the unit, e.g. "milliseconds".
+	Long string
+	// Scale converts seconds into the unit.
+	Scale float64
+}
+
+// unitAuto picks the timeUnit that fits the fastest query, see autoUnit.
+const unitAuto = "auto"
+
+// timeUnits are the units that can be selected via -unit.
+var timeUnits = map[string]timeUnit{
+	"s":  {Name: "s", Long: "seconds", Scale: 1},
+	"ms": {Name: "ms", Long: "milliseconds", Scale: 1e3},
+	"us": {Name: "us", Long: "microseconds", Scale: 1e6},
+}
+
+// resolveUnit returns the timeUnit for the given -unit value.
+func resolveUnit(name string, queries []*Query) timeUnit {
+	if name == unitAuto {
+		return autoUnit(queries)
+	} else if unit, ok := timeUnits[name]; ok {
+		return unit
+	}
+	return timeUnits["ms"]
+}
+
+// holdUnit returns the -unit value that fixes the unit picked by unitAuto
+// once any query has samples, so that the unit doesn't flip between redraws
+// as the fastest mean changes. Other values are returned as is.
+func holdUnit(name string, queries []*Query) string {
+	if name != unitAuto {
+		return name
+	}
+	for _, q := range queries {
+		if len(q.Seconds) > 0 {
+			return autoUnit(queries).Name
+		}
+	}
+	return name
+}
+
+// autoUnit returns the unit in which the mean of the fastest query has at
+// least one digit before the decimal point, but not more than three.
+func autoUnit(queries []*Query) timeUnit {
+	fastest := math.Inf(1)
+	for _, q := range queries {
+		if len(q.Seconds) > 0 && q.Mean < fastest {
+			fastest = q.Mean
+		}
+	}
+	switch {
+	case math.IsInf(fastest, 1):
+		return timeUnits["ms"]
+	case fastest < 1e-3:
+		return timeUnits["us"]
+	case fastest >= 1:
+		return timeUnits["s"]
+	default:
+		return timeUnits["ms"]
+	}
+}
+
+const (
+	// layoutColumns renders queries as columns and stats as rows.
+	layoutColumns = "columns"
+	// layoutRows renders queries as rows and stats as columns.
+	layoutRows = "rows"
+	// layoutAuto picks layoutColumns unless the table is too wide for the
+	// terminal, in which case layoutRows is used.
+	layoutAuto = "auto"
+)
+
+var tableLayouts = []string{layoutAuto, layoutColumns, layoutRows}
+
+func render(queries []*Query, opts renderOptions) error {
+	screen := &bytes.Buffer{}
+
+	if opts.Clear {
+		// See https://en.wikipedia.org/wiki/ANSI_escape_code#Terminal_output_sequences
+		// move cursor to 0, 0
+		fmt.Fprintf(screen, "\033[%d;%dH", 0, 0)
+		// reset screen
+		fmt.Fprintf(screen, "\033[2J\033[3J")
+	}
+
+	stats := displayStats(queries, opts)
+
+	termWidth := terminalWidth()
+	layout := opts.Layout
+	if layout == layoutAuto {
+		layout = layoutColumns
+		if autoNameWidth(termWidth, len(queries)) == minNameWidth {
+			layout = layoutRows
+		}
+	}
+
+	maxNameWidth := opts.MaxNameWidth
+	if maxNameWidth <= 0 && layout == layoutColumns {
+		maxNameWidth = autoNameWidth(termWidth, len(queries))
+	}
+
+	var names []string
+	for _, query := range queries {
+		names = append(names, elide(opts.queryLabel(query.Name), maxNameWidth))
+	}
+	cells := formatCells(queries, stats, opts)
+	// The top left cell of the table labels the unit of the durations, unless
+	// it's the default.
+	var unit string
+	if opts.Unit != "" && opts.Unit != "ms" {
+		unit = resolveUnit(opts.Unit, queries).Name
+	}
+
+	var headers []string
+	var rows [][]string
+	switch layout {
+	case layoutRows:
+		headers = []string{unit}
+		for _, stat := range stats {
+			headers = append(headers, stat.Name)
+		}
+		for i, name := range names {
+			rows = append(rows, append([]string{name}, cells[i]...))
+		}
+	default:
+		headers = append([]string{unit}, names...)
+		for j, stat := range stats {
+			row := []string{stat.Name}
+			for i := range names {
+				row = append(row, cells[i][j])
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	table := tablewriter.NewWriter(screen)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader(headers)
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.AppendBulk(rows)
+	table.Render()
+	if opts.Matrix && len(queries) > 1 {
+		fmt.Fprintf(screen, "\n")
+		renderMatrix(screen, queries, names, opts)
+	}
+	if len(opts.Baseline) > 0 {
+		if opts.BaselineLabel != "" || opts.CurrentLabel != "" {
+			fmt.Fprintf(screen, "\nRatios compare %s against %s.\n", opts.currentName(), opts.baselineName())
+		}
+		fmt.Fprintf(screen, "\n%s\n", suiteSummary(queries, opts.Baseline, opts))
+	}
+	screen.WriteTo(os.Stdout)
+	return nil
+}
+
+// displayStats returns the stats to display for queries, which are either the
+// opts.Stats or all non-hidden stats available for any of the queries. The
+// ratio of the opts.CompareStat is appended if set.
+func displayStats(queries []*Query, opts renderOptions) []tableStat {
+	var stats []tableStat
+	if len(opts.Stats) > 0 {
+		stats = append(stats, opts.Stats...)
+	} else {
+		for _, stat := range tableStats {
+			if !stat.Hidden && stat.available(queries) {
+				stats = append(stats, stat)
+			}
+		}
+	}
+	if opts.CompareStat != nil {
+		stats = append(stats, tableStat{
+			Name:      opts.CompareStat.Name + " ratio",
+			Value:     opts.CompareStat.Value,
+			RatioOnly: true,
+		})
+	}
+	return stats
+}
+
+// formatCells returns the formatted values of stats for each query,
+// annotated with the ratios to their reference queries.
+func formatCells(queries []*Query, stats []tableStat, opts renderOptions) [][]string {
+	unit := resolveUnit(opts.Unit, queries)
+	refs := refQueries(queries, opts)
+	var cells [][]string
+	for i, query := range queries {
+		var queryCells []string
+		for _, stat := range stats {
+			queryCells = append(queryCells, stat.format(query, opts.statRef(stat, refs[i]), len(opts.Baseline) > 0, opts.AbsDelta, unit))
+		}
+		cells = append(cells, queryCells)
+	}
+	return cells
+}
+
+// statRef returns ref, unless the ratio of stat is suppressed by the
+// CompareStat.
+func (o renderOptions) statRef(stat tableStat, ref *Query) *Query {
+	if o.CompareStat != nil && !stat.RatioOnly {
+		return nil
+	}
+	return ref
+}
+
+// refQueries returns the reference query that each of the queries is
+// compared against, or nil for queries that aren't compared.
+func refQueries(queries []*Query, opts renderOptions) []*Query {
+	baselineLookup := map[string]*Query{}
+	for _, query := range opts.Baseline {
+		baselineLookup[query.Name] = query
+	}
+
+	// refQuery is the query all other queries are compared against, if set
+	// via -baseline-query. It's taken from the -i baseline if given.
+	var refQuery *Query
+	if opts.BaselineQuery != "" {
+		candidates := queries
+		if len(opts.Baseline) > 0 {
+			candidates = opts.Baseline
+		}
+		refQuery = findQuery(candidates, opts.BaselineQuery)
+	}
+
+	var refs []*Query
+	for _, query := range queries {
+		var ref *Query
+		switch {
+		case refQuery != nil:
+			ref = refQuery
+		case len(opts.Baseline) > 0:
+			ref = baselineLookup[query.Name]
+		case len(queries) > 0:
+			ref = queries[0]
+		}
+		if ref == query {
+			ref = nil
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// renderMatrix writes a table of the ratios between the mean durations of
+// every pair of queries to w, or another stat given by opts.CompareStat. The
+// cell in row i and column j is the mean of query i divided by the mean of
+// query j, i.e. values above 1 mean that the row query is slower. names are
+// the display names of the queries.
+func renderMatrix(w io.Writer, queries []*Query, names []string, opts renderOptions) {
+	statName, value := opts.compareStat()
+	table := tablewriter.NewWriter(w)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader(append([]string{statName + " ratio"}, names...))
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for i, row := range queries {
+		cells := []string{names[i]}
+		for _, col := range queries {
+			switch {
+			case row == col:
+				cells = append(cells, "-")
+			case value(col) == 0:
+				cells = append(cells, "n/a")
+			default:
+				cells = append(cells, fmt.Sprintf("%.2fx", value(row)/value(col)))
+			}
+		}
+		table.Append(cells)
+	}
+	table.Render()
+}
+
+// suiteScore returns the geometric mean of the ratios between the values,
+// e.g. the mean durations, of queries and their counterparts in baseline, as
+// well as the number of query pairs it's based on. Queries that only exist on
+// one side are ignored.
+func suiteScore(queries, baseline []*Query, value func(*Query) float64) (float64, int) {
+	var logSum float64
+	var n int
+	for _, query := range queries {
+		ref := findQuery(baseline, query.Name)
+		if ref == nil || value(ref) <= 0 || value(query) <= 0 {
+			continue
+		}
+		logSum += math.Log(value(query) / value(ref))
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return math.Exp(logSum / float64(n)), n
+}
+
+// suiteSummary returns a one line summary of the suiteScore of the
+// opts.compareStat.
+func suiteSummary(queries, baseline []*Query, opts renderOptions) string {
+	statName, value := opts.compareStat()
+	score, n := suiteScore(queries, baseline, value)
+	basis := fmt.Sprintf("geometric mean of %d queries", n)
+	if opts.CompareStat != nil {
+		basis = fmt.Sprintf("geometric mean of the %s ratios of %d queries", statName, n)
+	}
+	switch {
+	case n == 0:
+		return fmt.Sprintf("Overall: no queries in common with %s.", opts.baselineName())
+	case score <= 1:
+		return fmt.Sprintf("Overall: %.2fx faster than %s (%s).", 1/score, opts.baselineName(), basis)
+	default:
+		return fmt.Sprintf("Overall: %.2fx slower than %s (%s).", score, opts.baselineName(), basis)
+	}
+}
+
+// findQuery returns the query with the given name or nil.
+func findQuery(queries []*Query, name string) *Query {
+	for _, query := range queries {
+		if query.Name == name {
+			return query
+		}
+	}
+	return nil
+}
+
+// meanWithinError returns true if the difference between the means of a and b
+// is within two combined standard errors, i.e. not statistically significant
+// at roughly the 95% level.
+func meanWithinError(a, b *Query) bool {
+	combinedSEM := math.Sqrt(a.SEM*a.SEM + b.SEM*b.SEM)
+	return math.Abs(a.Mean-b.Mean) <= 2*combinedSEM
+}
+
+// tableStat defines a statistic that is displayed in the results table.
+type tableStat struct {
+	// Name is the row or column label of the stat.
+	Name string
+	// Value returns the stat for the given query.
+	Value func(q *Query) float64
+	// Seconds indicates that Value is a duration in seconds which gets
+	// displayed in the -unit.
+	Seconds bool
+	// Format is the fmt verb used for displaying the value.
+	Format string
+	// Ratio controls when the value is annotated with its ratio to the
+	// reference query.
+	Ratio ratioMode
+	// Available returns false if the stat is not available for a query, e.g.
+	// because the method doesn't support it. Stats that are not available for
+	// any query are hidden by default. nil means always available.
+	Available func(q *Query) bool
+	// Hidden stats are only displayed when requested via -stats.
+	Hidden bool
+	// RatioOnly causes only the ratio to the reference query to be displayed,
+	// see -compare-stat.
+	RatioOnly bool
+}
+
+// ratioMode controls when a tableStat is annotated with a ratio.
+type ratioMode int
+
+const (
+	// ratioAlways compares the stat against the reference query.
+	ratioAlways ratioMode = iota
+	// ratioBaseline only compares the stat against a -i baseline.
+	ratioBaseline
+	// ratioNever doesn't annotate the stat.
+	ratioNever
+)
+
+// tableStats is the list of all stats that can be displayed by render. It's
+// rebuilt by setPercentiles.
+var tableStats = buildTableStats()
+
+// buildTableStats returns the tableStats for the current statPercentiles.
+func buildTableStats() []tableStat {
+	stats := []tableStat{
+		{Name: "n", Value: func(q *Query) float64 { return float64(q.N()) }, Format: "%.0f", Ratio: ratioBaseline},
+		{Name: "min", Value: func(q *Query) float64 { return q.Min }, Seconds: true},
+		{Name: "max", Value: func(q *Query) float64 { return q.Max }, Seconds: true},
+		{Name: "mean", Value: func(q *Query) float64 { return q.Mean }, Seconds: true},
+		{Name: "stddev", Value: func(q *Query) float64 { return q.StdDev }, Seconds: true},
+		{Name: "sem", Value: func(q *Query) float64 { return q.SEM }, Seconds: true},
+		{Name: "median", Value: func(q *Query) float64 { return q.Median }, Seconds: true},
+		{Name: "trimmed mean", Value: func(q *Query) float64 { return q.TrimmedMean }, Seconds: true, Available: func(*Query) bool { return trimPercent > 0 }},
+		{Name: "trimmed stddev", Value: func(q *Query) float64 { return q.TrimmedStdDev }, Seconds: true, Available: func(*Query) bool { return trimPercent > 0 }},
+	}
+	for i, p := range statPercentiles {
+		i := i
+		stats = append(stats, tableStat{
+			Name:    percentileName(p),
+			Value:   func(q *Query) float64 { return q.percentile(i) },
+			Seconds: true,
+		})
+	}
+	for i, p := range statPercentiles {
+		i := i
+		stats = append(stats, tableStat{
+			Name:      "corrected " + percentileName(p),
+			Value:     func(q *Query) float64 { return q.correctedPercentile(i) },
+			Seconds:   true,
+			Available: func(q *Query) bool { return len(q.CorrectedSeconds) > 0 },
+		})
+	}
+	stats = append(stats, []tableStat{
+		{Name: "min iter", Value: func(q *Query) float64 { return float64(q.MinIteration) }, Format: "%.0f", Ratio: ratioNever},
+		{Name: "max iter", Value: func(q *Query) float64 { return float64(q.MaxIteration) }, Format: "%.0f", Ratio: ratioNever},
+		{Name: "rows/s", Value: func(q *Query) float64 { return q.RowsPerSecond }, Available: func(q *Query) bool { return len(q.Rows) > 0 }},
+		{Name: "reads/row", Value: func(q *Query) float64 { return q.ReadsPerRow }, Format: "%.3f", Available: func(q *Query) bool { return len(q.ReadBlocks) > 0 }},
+		{Name: "temp blocks", Value: func(q *Query) float64 { return q.TempBlocksMean }, Format: "%.1f", Available: func(q *Query) bool { return len(q.TempBlocks) > 0 }},
+		{Name: "spills", Value: func(q *Query) float64 { return float64(q.Spills) }, Format: "%.0f", Ratio: ratioNever, Available: func(q *Query) bool { return q.Spills > 0 }},
+		{Name: "cost", Value: func(q *Query) float64 {
+			if q.Plan == nil {
+				return 0
+			}
+			return q.Plan.TotalCost
+		}, Format: "%.2f", Ratio: ratioNever, Available: func(q *Query) bool { return q.Plan != nil }, Hidden: true},
+		{Name: "row est error", Value: func(q *Query) float64 { return q.RowEstimateError }, Format: "%.1fx", Ratio: ratioNever, Available: func(q *Query) bool { return len(q.RowEstimateErrors) > 0 }, Hidden: true},
+		{Name: "plan mean", Value: func(q *Query) float64 { return q.PlanningMean }, Seconds: true, Available: func(q *Query) bool { return len(q.PlanningSeconds) > 0 }},
+		{Name: "exec mean", Value: func(q *Query) float64 { return q.ExecutionMean }, Seconds: true, Available: func(q *Query) bool { return len(q.ExecutionSeconds) > 0 }},
+		{Name: "first row", Value: func(q *Query) float64 { return q.FirstRowMean }, Seconds: true, Available: func(q *Query) bool { return len(q.FirstRowSeconds) > 0 }},
+		{Name: "queue mean", Value: func(q *Query) float64 { return q.QueueMean }, Seconds: true, Available: func(q *Query) bool { return len(q.QueueSeconds) > 0 }},
+		{Name: "queue max", Value: func(q *Query) float64 { return q.QueueMax }, Seconds: true, Available: func(q *Query) bool { return len(q.QueueSeconds) > 0 }},
+		{Name: "cpu mean", Value: func(q *Query) float64 { return q.BackendCPUMean }, Seconds: true, Available: func(q *Query) bool { return len(q.BackendCPU) > 0 }},
+		{Name: "peak rss", Value: func(q *Query) float64 { return q.BackendPeakRSS / (1 << 20) }, Format: "%.1f", Available: func(q *Query) bool { return q.BackendPeakRSS > 0 }},
+		{Name: "mean rss", Value: func(q *Query) float64 { return q.BackendMeanRSS / (1 << 20) }, Format: "%.1f", Available: func(q *Query) bool { return q.BackendMeanRSS > 0 }},
+		{Name: "plans", Value: func(q *Query) float64 { return float64(len(q.Plans)) }, Format: "%.0f", Ratio: ratioNever, Available: func(q *Query) bool { return q.Plan != nil }},
+		{Name: "errors", Value: func(q *Query) float64 { return q.Errors }},
+		{Name: "failed", Value: func(q *Query) float64 { return float64(q.Failures) }, Format: "%.0f", Ratio: ratioNever, Available: func(q *Query) bool { return q.Failures > 0 }},
+		{Name: "error rate", Value: func(q *Query) float64 { return q.ErrorRate() * 100 }, Format: "%.1f%%", Ratio: ratioNever, Available: func(q *Query) bool { return q.Failures > 0 }},
+		{Name: "capped", Value: func(q *Query) float64 { return float64(q.Capped) }, Format: "%.0f", Ratio: ratioNever, Available: func(q *Query) bool { return q.Capped > 0 }},
+		{Name: "warmup", Value: func(q *Query) float64 { return float64(q.Warmup) }, Format: "%.0f", Ratio: ratioNever, Hidden: true},
+		{Name: "steady mean", Value: func(q *Query) float64 { return q.SteadyMean }, Seconds: true, Hidden: true},
+		{Name: "steady median", Value: func(q *Query) float64 { return q.SteadyMedian }, Seconds: true, Hidden: true},
+	}...)
+	for _, name := range sampleStatNames() {
+		fn := sampleStats[name]
+		stats = append(stats, tableStat{
+			Name: name,
+			Value: func(q *Query) float64 {
+				// Queries without samples are displayed as 0, like for the
+				// other stats.
+				v, err := fn(q.Seconds)
+				if err != nil {
+					return 0
+				}
+				return v
+			},
+			Seconds: true,
+			Hidden:  true,
+		})
+	}
+	return stats
+}
+
+// statPercentiles are the percentiles computed for every query, see
+// -percentiles.
+var statPercentiles = []float64{90, 95}
+
+// setPercentiles sets the statPercentiles and rebuilds the tableStats.
+func setPercentiles(percentiles []float64) {
+	statPercentiles = percentiles
+	tableStats = buildTableStats()
+}
+
+// parsePercentiles parses a comma separated list of percentiles. Duplicates
+// are omitted, since they'd result in duplicate stats.
+func parsePercentiles(list string) ([]float64, error) {
+	var percentiles []float64
+	seen := map[float64]bool{}
+	for _, item := range splitList(list) {
+		p, err := strconv.ParseFloat(item, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("bad percentile: %q: must be a number in (0, 100]", item)
+		} else if seen[p] {
+			continue
+		}
+		seen[p] = true
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// percentileName returns the stat name of the given percentile, e.g. p99.9.
+func percentileName(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// parseTableStats returns the tableStats for the given comma separated list
+// of stat names.
+func parseTableStats(list string) ([]tableStat, error) {
+	var stats []tableStat
+	for _, name := range splitList(list) {
+		var found bool
+		for _, stat := range tableStats {
+			if stat.Name == name {
+				stats = append(stats, stat)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown stat: %q: must be one of %s", name, tableStatNames())
+		}
+	}
+	return stats, nil
+}
+
+// tableStatNames returns the list of valid -stats values.
+func tableStatNames() string {
+	var list []string
+	for _, stat := range tableStats {
+		list = append(list, fmt.Sprintf("%q", stat.Name))
+	}
+	return strings.Join(list, ", ")
+}
+
+// hiddenTableStatNames returns the list of stats that are only displayed when
+// requested via -stats.
+func hiddenTableStatNames() string {
+	var list []string
+	for _, stat := range tableStats {
+		if stat.Hidden {
+			list = append(list, fmt.Sprintf("%q", stat.Name))
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+// available returns true if the stat is available for any of the queries.
+func (s tableStat) available(queries []*Query) bool {
+	if s.Available == nil {
+		return true
+	}
+	for _, q := range queries {
+		if s.Available(q) {
+			return true
+		}
+	}
+	return false
+}
+
+// format returns the formatted value of the stat for q, annotated with the
+// ratio to ref if applicable. ref may be nil. Durations are displayed in
+// unit. If absDelta is true, they are annotated with their difference to ref
+// as well.
+func (s tableStat) format(q, ref *Query, hasBaseline, absDelta bool, unit timeUnit) string {
+	if s.RatioOnly {
+		if ref == nil || s.Value(ref) == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2fx", s.Value(q)/s.Value(ref))
+	}
+	value := s.Value(q)
+	if s.Seconds {
+		value *= unit.Scale
+	}
+	format := s.Format
+	if format == "" {
+		format = "%.2f"
+	}
+	str := fmt.Sprintf(format, value)
+
+	ratio, ok := s.ratio(q, ref, hasBaseline)
+	if !ok {
+		return str
+	}
+	approx := ""
+	if s.Name == "mean" && meanWithinError(q, ref) {
+		approx = "≈"
+	}
+	if absDelta && s.Seconds {
+		refValue := s.Value(ref) * unit.Scale
+		return fmt.Sprintf("%s (%+.2f%s, %s%.2fx)", str, value-refValue, unit.Name, approx, ratio)
+	}
+	return fmt.Sprintf("%s (%s%.2fx)", str, approx, ratio)
+}
+
+// ratio returns the ratio of the stat for q to ref, and false if the stat
+// isn't compared against ref. ref may be nil.
+func (s tableStat) ratio(q, ref *Query, hasBaseline bool) (float64, bool) {
+	if ref == nil || s.Ratio == ratioNever || (s.Ratio == ratioBaseline && !hasBaseline) {
+		return 0, false
+	}
+	refValue := s.Value(ref)
+	if refValue == 0 {
+		return 0, false
+	}
+	return s.Value(q) / refValue, true
+}
+
+// minNameWidth is the width below which query names become unrecognizable,
+// so we'll rather let the table wrap at this point.
+const minNameWidth = 8
+
+// autoNameWidth returns the maximum query name width that allows a table with
+// the given number of query columns to fit into a terminal of the given
+// width. It returns 0 if the terminal width is unknown.
+func autoNameWidth(termWidth, columns int) int {
+	// colPadding accounts for the " | " separator tablewriter puts between
+	// columns.
+	const colPadding = 3
+	if termWidth <= 0 || columns <= 0 {
+		return 0
+	}
+	// The first column holds the stat names. Hidden stats are rarely shown,
+	// so we don't reserve space for them.
+	var labelWidth int
+	for _, stat := range tableStats {
+		if !stat.Hidden && len(stat.Name) > labelWidth {
+			labelWidth = len(stat.Name)
+		}
+	}
+	width := (termWidth-labelWidth-colPadding)/columns - colPadding
+	if width < minNameWidth {
+		return minNameWidth
+	}
+	return width
+}
+
+// terminalWidth returns the width of the terminal connected to stdout, or 0
+// if it can't be determined. The COLUMNS environment variable takes
+// precedence over the actual terminal size.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// elide truncates s to maxWidth characters by replacing its tail with an
+// ellipsis. s is returned unmodified if maxWidth <= 0.
+func elide(s string, maxWidth int) string {
+	runes := []rune(s)
+	if maxWidth <= 0 || len(runes) <= maxWidth {
+		return s
+	}
+	return string(runes[:maxWidth-1]) + "…"
+}
+
+// timeUnitNames returns the values accepted by -unit.
+func timeUnitNames() string {
+	list := []string{}
+	for _, name := range []string{"us", "ms", "s", unitAuto} {
+		list = append(list, fmt.Sprintf("%q", name))
+	}
+	return strings.Join(list, ", ")
+}
+
+// tableLayoutNames returns the list of valid -layout values.
+func tableLayoutNames() string {
+	var list []string
+	for _, layout := range tableLayouts {
+		list = append(list, fmt.Sprintf("%q", layout))
+	}
+	return strings.Join(list, ", ")
+}
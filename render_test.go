@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_elide(t *testing.T) {
+	tests := []struct {
+		In       string
+		MaxWidth int
+		Want     string
+	}{
+		{"recursive", 0, "recursive"},
+		{"recursive", 9, "recursive"},
+		{"recursive", 5, "recu…"},
+		{"größenordnung", 4, "grö…"},
+	}
+	for _, test := range tests {
+		if got := elide(test.In, test.MaxWidth); got != test.Want {
+			t.Errorf("elide(%q, %d): got=%q want=%q", test.In, test.MaxWidth, got, test.Want)
+		}
+	}
+}
+
+func Test_meanWithinError(t *testing.T) {
+	a := &Query{Mean: 1.0, SEM: 0.1}
+	if !meanWithinError(a, &Query{Mean: 1.2, SEM: 0.1}) {
+		t.Error("got=false want=true")
+	} else if meanWithinError(a, &Query{Mean: 1.5, SEM: 0.1}) {
+		t.Error("got=true want=false")
+	}
+}
+
+func Test_parseTableStats(t *testing.T) {
+	stats, err := parseTableStats("n, median,p95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, stat := range stats {
+		names = append(names, stat.Name)
+	}
+	if got, want := strings.Join(names, ","), "n,median,p95"; got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+
+	if _, err := parseTableStats("n,p99.9"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func Test_suiteScore(t *testing.T) {
+	queries := []*Query{{Name: "a", Mean: 2}, {Name: "b", Mean: 1}, {Name: "new", Mean: 5}}
+	baseline := []*Query{{Name: "a", Mean: 1}, {Name: "b", Mean: 2}, {Name: "gone", Mean: 3}}
+	score, n := suiteScore(queries, baseline, func(q *Query) float64 { return q.Mean })
+	if n != 2 {
+		t.Fatalf("got=%d want=%d", n, 2)
+	} else if math.Abs(score-1) > 1e-9 {
+		t.Fatalf("got=%f want=%f", score, 1.0)
+	}
+}
+
+func Test_suiteSummary_labels(t *testing.T) {
+	queries := []*Query{{Name: "a", Mean: 1}}
+	baseline := []*Query{{Name: "a", Mean: 2}}
+	opts := renderOptions{Baseline: baseline, BaselineLabel: "pg13", CurrentLabel: "pg14"}
+	if got, want := suiteSummary(queries, baseline, opts), "Overall: 2.00x faster than pg13 (geometric mean of 1 queries)."; got != want {
+		t.Errorf("got=%q want=%q", got, want)
+	}
+	if got, want := opts.queryLabel("a"), "a [pg14]"; got != want {
+		t.Errorf("got=%q want=%q", got, want)
+	}
+	if got, want := (renderOptions{CurrentLabel: "pg14"}).queryLabel("a"), "a"; got != want {
+		t.Errorf("got=%q want=%q without baseline", got, want)
+	}
+}
+
+func Test_parsePercentiles(t *testing.T) {
+	percentiles, err := parsePercentiles("50, 99,99.9,99.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range percentiles {
+		names = append(names, percentileName(p))
+	}
+	if got, want := strings.Join(names, ","), "p50,p99,p99.9"; got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+
+	for _, bad := range []string{"0", "100.1", "-5", "p50"} {
+		if _, err := parsePercentiles(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func Test_tableStat_format(t *testing.T) {
+	stat := tableStat{Name: "max", Value: func(q *Query) float64 { return q.Max }, Seconds: true}
+	q, ref := &Query{Max: 0.0025}, &Query{Max: 0.002}
+	ms := timeUnits["ms"]
+	if got, want := stat.format(q, ref, false, false, ms), "2.50 (1.25x)"; got != want {
+		t.Errorf("got=%q want=%q", got, want)
+	}
+	if got, want := stat.format(q, ref, false, true, ms), "2.50 (+0.50ms, 1.25x)"; got != want {
+		t.Errorf("got=%q want=%q", got, want)
+	}
+	if got, want := stat.format(ref, q, false, true, ms), "2.00 (-0.50ms, 0.80x)"; got != want {
+		t.Errorf("got=%q want=%q", got, want)
+	}
+	if got, want := stat.format(q, ref, false, true, timeUnits["us"]), "2500.00 (+500.00us, 1.25x)"; got != want {
+		t.Errorf("got=%q want=%q", got, want)
+	}
+}
+
+func Test_formatCells_compareStat(t *testing.T) {
+	stats, err := parseTableStats("mean,median")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []*Query{{Name: "a", Mean: 0.002, Median: 0.001}, {Name: "b", Mean: 0.003, Median: 0.003}}
+	opts := renderOptions{Stats: stats, CompareStat: &stats[1]}
+	cells := formatCells(queries, displayStats(queries, opts), opts)
+	want := [][]string{{"2.00", "1.00", "-"}, {"3.00", "3.00", "3.00x"}}
+	if fmt.Sprint(cells) != fmt.Sprint(want) {
+		t.Fatalf("got=%q want=%q", cells, want)
+	}
+}
+
+func Test_autoUnit(t *testing.T) {
+	tests := []struct {
+		Means []float64
+		Want  string
+	}{
+		{nil, "ms"},
+		{[]float64{0.0004, 0.002}, "us"},
+		{[]float64{0.05, 0.002}, "ms"},
+		{[]float64{2.5, 1}, "s"},
+	}
+	for _, test := range tests {
+		var queries []*Query
+		for _, mean := range test.Means {
+			queries = append(queries, &Query{Seconds: []float64{mean}, Mean: mean})
+		}
+		if got := autoUnit(queries).Name; got != test.Want {
+			t.Errorf("means=%v: got=%q want=%q", test.Means, got, test.Want)
+		}
+	}
+}
+
+func Test_holdUnit(t *testing.T) {
+	q := &Query{}
+	if got := holdUnit(unitAuto, []*Query{q}); got != unitAuto {
+		t.Errorf("no samples: got=%q want=%q", got, unitAuto)
+	}
+	q.Seconds, q.Mean = []float64{0.0004}, 0.0004
+	unit := holdUnit(unitAuto, []*Query{q})
+	if unit != "us" {
+		t.Errorf("got=%q want=%q", unit, "us")
+	}
+	q.Seconds, q.Mean = append(q.Seconds, 0.5), 0.25
+	if got := holdUnit(unit, []*Query{q}); got != "us" {
+		t.Errorf("held: got=%q want=%q", got, "us")
+	}
+}
+
+func Test_sampleStats(t *testing.T) {
+	stats, err := parseTableStats("hmean,iqr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &Query{Seconds: []float64{1, 2, 4}}
+	if got, want := stats[0].Value(q), 12.0/7; math.Abs(got-want) > 1e-9 {
+		t.Errorf("hmean: got=%f want=%f", got, want)
+	}
+	if got := stats[1].Value(&Query{}); got != 0 {
+		t.Errorf("iqr without samples: got=%f want=0", got)
+	}
+	for _, stat := range tableStats {
+		if _, ok := sampleStats[stat.Name]; ok && !stat.Hidden {
+			t.Errorf("%s: sample stats should be hidden by default", stat.Name)
+		}
+	}
+}
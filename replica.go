@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// replicaStatus describes whether a database is a replica and how far it
+// lags behind its primary, see -report-replica-lag.
+type replicaStatus struct {
+	// Replica is true if the database is in recovery, i.e. a standby.
+	Replica bool
+	// Lag is the time since the last transaction replayed from the primary
+	// committed, or -1 if no transaction was replayed yet. Only set for
+	// replicas.
+	Lag time.Duration
+}
+
+// queryReplicaStatus returns the replicaStatus of db. Note that the lag keeps
+// growing while the primary is idle, since it's based on the commit time of
+// the last replayed transaction.
+func queryReplicaStatus(ctx context.Context, db *sql.DB) (replicaStatus, error) {
+	var (
+		status replicaStatus
+		lag    sql.NullFloat64
+	)
+	row := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery(), extract(epoch FROM now() - pg_last_xact_replay_timestamp())::float8")
+	if err := row.Scan(&status.Replica, &lag); err != nil {
+		return status, fmt.Errorf("failed to determine replica status: %w", err)
+	}
+	status.Lag = -1
+	if lag.Valid {
+		status.Lag = time.Duration(lag.Float64 * float64(time.Second))
+	}
+	return status, nil
+}
+
+// replicaSummary returns a one line summary of the replica status of a
+// database at the start and end of the benchmark.
+func replicaSummary(start, end replicaStatus) string {
+	if !start.Replica && !end.Replica {
+		return "no"
+	} else if start.Replica != end.Replica {
+		return "changed during the benchmark, e.g. due to a failover"
+	}
+	lag := func(s replicaStatus) string {
+		if s.Lag < 0 {
+			return "unknown (no transaction replayed yet)"
+		}
+		return s.Lag.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("yes, replay lag %s at start, %s at end", lag(start), lag(end))
+}
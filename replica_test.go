@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_replicaSummary(t *testing.T) {
+	primary := replicaStatus{}
+	tests := []struct {
+		Start, End replicaStatus
+		Want       string
+	}{
+		{primary, primary, "no"},
+		{replicaStatus{Replica: true, Lag: 1200 * time.Millisecond}, replicaStatus{Replica: true, Lag: 800 * time.Millisecond}, "yes, replay lag 1.2s at start, 800ms at end"},
+		{replicaStatus{Replica: true, Lag: -1}, replicaStatus{Replica: true, Lag: 0}, "yes, replay lag unknown (no transaction replayed yet) at start, 0s at end"},
+		{replicaStatus{Replica: true}, primary, "changed during the benchmark, e.g. due to a failover"},
+	}
+	for _, test := range tests {
+		if got := replicaSummary(test.Start, test.End); got != test.Want {
+			t.Errorf("got=%q want=%q", got, test.Want)
+		}
+	}
+}
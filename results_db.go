@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resultsDBSchema is the schema of the SQLite database written by
+// writeResultsDB. Durations are stored in seconds.
+const resultsDBSchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	started_at TEXT NOT NULL,
+	finished_at TEXT NOT NULL,
+	git_sha TEXT,
+	sqlbench_version TEXT NOT NULL,
+	method TEXT NOT NULL,
+	args TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS query_stats (
+	run_id INTEGER NOT NULL REFERENCES runs (id),
+	query TEXT NOT NULL,
+	sql_hash TEXT NOT NULL,
+	n INTEGER NOT NULL,
+	min REAL,
+	max REAL,
+	mean REAL,
+	stddev REAL,
+	median REAL,
+	errors INTEGER NOT NULL,
+	PRIMARY KEY (run_id, query)
+);
+CREATE TABLE IF NOT EXISTS run_labels (
+	run_id INTEGER NOT NULL REFERENCES runs (id),
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (run_id, key)
+);
+`
+
+// resultsRun holds the metadata of a run written by writeResultsDB.
+type resultsRun struct {
+	Times  runTimes
+	GitSHA string
+	Method string
+	Args   string
+	Labels map[string]string
+}
+
+// checkResultsDB returns an error if writeResultsDB can't work, so that a
+// benchmark doesn't fail after running for a long time.
+func checkResultsDB() error {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("requires the sqlite3 command line tool: %w", err)
+	}
+	return nil
+}
+
+// writeResultsDB inserts run and the final stats of queries into the SQLite
+// database at path, creating it if needed, see -results-db. The database is
+// written via the sqlite3 command line tool, which avoids depending on cgo.
+func writeResultsDB(path string, run resultsRun, queries []*Query) error {
+	cmd := exec.Command("sqlite3", "-bail", path)
+	cmd.Stdin = strings.NewReader(resultsDBScript(run, queries))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sqlite3: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// resultsDBScript returns the SQL script executed by writeResultsDB.
+func resultsDBScript(run resultsRun, queries []*Query) string {
+	var sb strings.Builder
+	sb.WriteString(resultsDBSchema)
+	sb.WriteString("BEGIN IMMEDIATE;\n")
+	gitSHA := "NULL"
+	if run.GitSHA != "" {
+		gitSHA = sqliteString(run.GitSHA)
+	}
+	fmt.Fprintf(&sb,
+		"INSERT INTO runs (started_at, finished_at, git_sha, sqlbench_version, method, args) VALUES (%s, %s, %s, %s, %s, %s);\n",
+		sqliteString(run.Times.Start.UTC().Format(time.RFC3339)),
+		sqliteString(run.Times.End.UTC().Format(time.RFC3339)),
+		gitSHA,
+		sqliteString(version),
+		sqliteString(run.Method),
+		sqliteString(run.Args),
+	)
+	for _, q := range queries {
+		fmt.Fprintf(&sb,
+			"INSERT INTO query_stats (run_id, query, sql_hash, n, min, max, mean, stddev, median, errors) VALUES ((SELECT max(id) FROM runs), %s, %s, %d, %s, %s, %s, %s, %s, %.0f);\n",
+			sqliteString(q.Name),
+			sqliteString(q.SQLHash),
+			q.N(),
+			sqliteFloat(q.Min),
+			sqliteFloat(q.Max),
+			sqliteFloat(q.Mean),
+			sqliteFloat(q.StdDev),
+			sqliteFloat(q.Median),
+			q.Errors,
+		)
+	}
+	for _, key := range sortedKeys(run.Labels) {
+		fmt.Fprintf(&sb,
+			"INSERT INTO run_labels (run_id, key, value) VALUES ((SELECT max(id) FROM runs), %s, %s);\n",
+			sqliteString(key),
+			sqliteString(run.Labels[key]),
+		)
+	}
+	sb.WriteString("COMMIT;\n")
+	return sb.String()
+}
+
+// sqliteString returns s as an SQL string literal.
+func sqliteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqliteFloat returns f as an SQL literal, which is NULL for NaN and
+// infinite values.
+func sqliteFloat(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "NULL"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
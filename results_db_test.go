@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_writeResultsDB(t *testing.T) {
+	if err := checkResultsDB(); err != nil {
+		t.Skip(err)
+	}
+	queries := []*Query{
+		{Name: "a", SQLHash: "aaaa", Seconds: []float64{1, 3}},
+		{Name: "it's", SQLHash: "bbbb", Seconds: []float64{2}},
+	}
+	for _, q := range queries {
+		if err := q.UpdateStats(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	run := resultsRun{Times: runTimes{Start: start, End: start.Add(time.Minute)}, GitSHA: "abc123", Method: "client", Labels: map[string]string{"branch": "main"}}
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	for i := 0; i < 2; i++ {
+		if err := writeResultsDB(path, run, queries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := exec.Command("sqlite3", path, "SELECT r.id, r.git_sha, s.query, s.n, s.mean FROM runs r JOIN query_stats s ON s.run_id = r.id ORDER BY 1, 3").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1|abc123|a|2|2.0\n1|abc123|it's|1|2.0\n2|abc123|a|2|2.0\n2|abc123|it's|1|2.0"
+	if got := strings.TrimSpace(string(out)); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	out, err = exec.Command("sqlite3", path, "SELECT run_id, key, value FROM run_labels ORDER BY 1").Output()
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.TrimSpace(string(out)), "1|branch|main\n2|branch|main"; got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/montanaflynn/stats"
+)
+
+// sampleStats maps the names of additional stats to functions computing them
+// from the durations of a query in seconds. They're hidden by default, but can
+// be displayed via -stats or used for -sort. A new stat only needs an entry
+// here, but its name must not clash with the other tableStats.
+var sampleStats = map[string]func(seconds []float64) (float64, error){
+	"hmean": func(s []float64) (float64, error) { return stats.HarmonicMean(s) },
+	"gmean": func(s []float64) (float64, error) { return stats.GeometricMean(s) },
+	// trimean is the weighted average of the median and the quartiles.
+	"trimean": func(s []float64) (float64, error) { return stats.Trimean(s) },
+	// mad is the median absolute deviation.
+	"mad": func(s []float64) (float64, error) { return stats.MedianAbsoluteDeviation(s) },
+	// iqr is the interquartile range.
+	"iqr": func(s []float64) (float64, error) { return stats.InterQuartileRange(s) },
+}
+
+// sampleStatNames returns the names of the sampleStats in alphabetical order.
+func sampleStatNames() []string {
+	var names []string
+	for name := range sampleStats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,93 @@
+package main
+
+import "math"
+
+// steadyStateBatchSize is the number of consecutive samples that are averaged
+// into a batch by warmupSamples.
+const steadyStateBatchSize = 5
+
+// warmupSamples returns the number of leading samples that belong to the
+// ramp-up phase of a benchmark, e.g. while caches are still being populated.
+// It uses the MSER-5 heuristic which picks the truncation point that
+// minimizes the standard error of the mean of the remaining batches. At most
+// half of the samples are considered to be warmup.
+func warmupSamples(seconds []float64) int {
+	batches := make([]float64, len(seconds)/steadyStateBatchSize)
+	for i := range batches {
+		var sum float64
+		for _, s := range seconds[i*steadyStateBatchSize : (i+1)*steadyStateBatchSize] {
+			sum += s
+		}
+		batches[i] = sum / steadyStateBatchSize
+	}
+
+	var (
+		sum, sumSq float64
+		best       = math.Inf(1)
+		bestStart  int
+	)
+	// Iterate backwards, so the sums cover the batches after the truncation
+	// point, and so earlier truncation points win ties.
+	for d := len(batches) - 1; d >= 0; d-- {
+		sum += batches[d]
+		sumSq += batches[d] * batches[d]
+		if d >= (len(batches)+1)/2 {
+			continue
+		}
+		m := float64(len(batches) - d)
+		mean := sum / m
+		variance := math.Max(sumSq/m-mean*mean, 0)
+		if score := variance / m; score <= best {
+			best = score
+			bestStart = d
+		}
+	}
+	return bestStart * steadyStateBatchSize
+}
+
+// maxWarmupWindows limits the warmup of warmupDetector to the given number
+// of windows, so that queries that never stabilize don't block the benchmark.
+const maxWarmupWindows = 100
+
+// warmupDetector decides when the warmup of a query can end because its
+// durations have stabilized, see -warmup-until-stable. This is the case once
+// the mean of the last window samples is within tolerance of the mean of the
+// window before, relative to the latter.
+type warmupDetector struct {
+	window    int
+	tolerance float64
+	samples   []float64
+}
+
+// Add records the duration of a warmup execution.
+func (d *warmupDetector) Add(seconds float64) {
+	d.samples = append(d.samples, seconds)
+}
+
+// Stable returns true if the durations have stabilized.
+func (d *warmupDetector) Stable() bool {
+	n := len(d.samples)
+	if n < 2*d.window {
+		return false
+	}
+	mean := func(s []float64) float64 {
+		var sum float64
+		for _, v := range s {
+			sum += v
+		}
+		return sum / float64(len(s))
+	}
+	prev, last := mean(d.samples[n-2*d.window:n-d.window]), mean(d.samples[n-d.window:])
+	return math.Abs(last-prev) <= d.tolerance*prev
+}
+
+// Done returns true if the warmup should end, either because the durations
+// have stabilized or because maxWarmupWindows were exceeded.
+func (d *warmupDetector) Done() bool {
+	return d.Stable() || len(d.samples) >= maxWarmupWindows*d.window
+}
+
+// N returns the number of warmup executions so far.
+func (d *warmupDetector) N() int {
+	return len(d.samples)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_warmupSamples(t *testing.T) {
+	var seconds []float64
+	for i := 0; i < 20; i++ {
+		seconds = append(seconds, 10-float64(i)*0.4)
+	}
+	for i := 0; i < 80; i++ {
+		seconds = append(seconds, 2+float64(i%3)*0.01)
+	}
+	if got := warmupSamples(seconds); got < 15 || got > 25 {
+		t.Fatalf("got=%d want ~20", got)
+	}
+
+	flat := []float64{1, 1.01, 0.99, 1, 1.01, 0.99, 1, 1.01, 0.99, 1}
+	if got := warmupSamples(flat); got != 0 {
+		t.Fatalf("got=%d want=0", got)
+	}
+	if got := warmupSamples(seconds[:3]); got != 0 {
+		t.Fatalf("got=%d want=0", got)
+	}
+}
+
+func Test_warmupDetector(t *testing.T) {
+	d := &warmupDetector{window: 3, tolerance: 0.05}
+	// Caches warming up, then steady around 1.
+	for _, s := range []float64{10, 5, 2, 1.2, 1, 1.01, 0.99, 1, 1.02, 1, 1.01, 0.99} {
+		if d.Done() {
+			break
+		}
+		d.Add(s)
+	}
+	if !d.Stable() || d.N() != 10 {
+		t.Fatalf("got stable=%v n=%d want stable=true n=10", d.Stable(), d.N())
+	}
+
+	drifting := &warmupDetector{window: 2, tolerance: 0.01}
+	for i := 0; !drifting.Done(); i++ {
+		// Keeps getting slower, e.g. due to a growing table.
+		drifting.Add(math.Pow(1.1, float64(i)))
+	}
+	if drifting.Stable() || drifting.N() != maxWarmupWindows*2 {
+		t.Fatalf("got stable=%v n=%d want stable=false n=%d", drifting.Stable(), drifting.N(), maxWarmupWindows*2)
+	}
+}
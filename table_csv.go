@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+)
+
+// writeTableCSVFile writes the stats table of queries to a CSV file at path,
+// see -table-out and writeTableCSV.
+func writeTableCSVFile(path string, queries []*Query, opts renderOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := writeTableCSV(file, queries, opts); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeTableCSV writes the stats table of queries to w as CSV, with a row for
+// every query and a column for every stat. Unlike the rendered table, values
+// are written as plain numbers for spreadsheets, with durations in the -unit,
+// and the ratios to the reference queries go into separate "<stat> ratio"
+// columns, which are empty for queries that aren't compared.
+func writeTableCSV(w io.Writer, queries []*Query, opts renderOptions) error {
+	unit := resolveUnit(opts.Unit, queries)
+	stats := displayStats(queries, opts)
+	refs := refQueries(queries, opts)
+	hasBaseline := len(opts.Baseline) > 0
+
+	// hasRatio is true for stats that are compared against any query.
+	hasRatio := make([]bool, len(stats))
+	for j, stat := range stats {
+		for i, q := range queries {
+			if _, ok := stat.ratio(q, opts.statRef(stat, refs[i]), hasBaseline); ok && !stat.RatioOnly {
+				hasRatio[j] = true
+			}
+		}
+	}
+
+	header := []string{"query"}
+	for j, stat := range stats {
+		name := stat.Name
+		if stat.Seconds {
+			name += " (" + unit.Name + ")"
+		}
+		header = append(header, name)
+		if hasRatio[j] {
+			header = append(header, stat.Name+" ratio")
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for i, q := range queries {
+		record := []string{opts.queryLabel(q.Name)}
+		for j, stat := range stats {
+			ratio, ok := stat.ratio(q, opts.statRef(stat, refs[i]), hasBaseline)
+			switch {
+			case stat.RatioOnly && ok:
+				record = append(record, formatCSVFloat(ratio))
+			case stat.RatioOnly:
+				record = append(record, "")
+			default:
+				value := stat.Value(q)
+				if stat.Seconds {
+					value *= unit.Scale
+				}
+				record = append(record, formatCSVFloat(value))
+			}
+			if !hasRatio[j] {
+				continue
+			} else if ok {
+				record = append(record, formatCSVFloat(ratio))
+			} else {
+				record = append(record, "")
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatCSVFloat formats v with the fewest digits that represent it exactly.
+func formatCSVFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_writeTableCSV(t *testing.T) {
+	stats, err := parseTableStats("n,mean,spills")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []*Query{
+		{Name: "a", Seconds: []float64{0.002, 0.002}, Mean: 0.002},
+		{Name: "b, fast", Seconds: []float64{0.001}, Mean: 0.001, Spills: 1},
+	}
+	var buf bytes.Buffer
+	if err := writeTableCSV(&buf, queries, renderOptions{Stats: stats, Unit: "ms"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "query,n,mean (ms),mean ratio,spills\n" +
+		"a,2,2,,0\n" +
+		"\"b, fast\",1,1,0.5,1\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"text/template"
+)
+
+// isQueryTemplate returns true if sql contains template actions such as
+// {{randint 1 1000}}.
+func isQueryTemplate(sql string) bool {
+	return strings.Contains(sql, "{{")
+}
+
+// parseQueryTemplate parses sql as a text/template that generates a new
+// variant of the query for every execution. The random values of the
+// template functions are taken from rng.
+func parseQueryTemplate(name, sql string, rng *rand.Rand) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs(rng)).Parse(sql)
+}
+
+// templateFuncs returns the functions available in query templates.
+func templateFuncs(rng *rand.Rand) template.FuncMap {
+	return template.FuncMap{
+		// randint returns a random integer in the interval [min, max].
+		"randint": func(min, max int64) (int64, error) {
+			if max < min {
+				return 0, errors.New("randint: max must be >= min")
+			}
+			return min + rng.Int63n(max-min+1), nil
+		},
+		// pick returns one of its arguments at random.
+		"pick": func(choices ...interface{}) (interface{}, error) {
+			if len(choices) == 0 {
+				return nil, errors.New("pick: requires at least one argument")
+			}
+			return choices[rng.Intn(len(choices))], nil
+		},
+	}
+}
+
+// renderQueryTemplate executes tmpl and returns the resulting SQL.
+func renderQueryTemplate(tmpl *template.Template) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, nil); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func Test_parseQueryTemplate(t *testing.T) {
+	const sql = `SELECT * FROM t WHERE id = {{randint 1 3}} AND kind = '{{pick "a" "b"}}';`
+	render := func(seed int64) []string {
+		tmpl, err := parseQueryTemplate("q", sql, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for i := 0; i < 20; i++ {
+			rendered, err := renderQueryTemplate(tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, rendered)
+		}
+		return out
+	}
+
+	a, b := render(1), render(1)
+	distinct := map[string]bool{}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("got=%q want=%q for the same seed", b[i], a[i])
+		}
+		distinct[a[i]] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("got %d distinct queries, want several", len(distinct))
+	}
+
+	tmpl, err := parseQueryTemplate("q", "SELECT {{randint 2 1}}", rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := renderQueryTemplate(tmpl); err == nil {
+		t.Fatal("expected error for randint with max < min")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queryResult summarizes the result set of executing a query once, see
+// -verify.
+type queryResult struct {
+	Rows int
+	// Hash is a hash of all values returned by the query. It doesn't depend
+	// on the order of the rows, since equivalent queries without ORDER BY may
+	// return them in any order.
+	Hash string
+}
+
+// verifyResults executes each query once on conn and returns an error if
+// they don't all return the same result set. Queries that are templates are
+// skipped, since every execution runs a different variant of them.
+func verifyResults(ctx context.Context, conn *sql.Conn, queries []*Query) error {
+	results := map[*Query]queryResult{}
+	var verified []*Query
+	for _, q := range queries {
+		if q.Template != nil {
+			fmt.Fprintf(os.Stderr, "Warning: -verify: skipping template query %s\n", q.Name)
+			continue
+		}
+		result, err := fetchResult(ctx, conn, q.SQL)
+		if err != nil {
+			return fmt.Errorf("-verify: %s: %w", q.Name, err)
+		}
+		results[q] = result
+		verified = append(verified, q)
+	}
+	return compareResults(verified, results)
+}
+
+// compareResults returns an error describing the differing results if not
+// all queries have the same result.
+func compareResults(queries []*Query, results map[*Query]queryResult) error {
+	var (
+		hashes []string
+		names  = map[string][]string{}
+		rows   = map[string]int{}
+	)
+	for _, q := range queries {
+		result := results[q]
+		if _, ok := names[result.Hash]; !ok {
+			hashes = append(hashes, result.Hash)
+		}
+		names[result.Hash] = append(names[result.Hash], q.Name)
+		rows[result.Hash] = result.Rows
+	}
+	if len(hashes) <= 1 {
+		return nil
+	}
+	var groups []string
+	for _, hash := range hashes {
+		groups = append(groups, fmt.Sprintf("%s: %d rows (result hash %s)", strings.Join(names[hash], ", "), rows[hash], hash))
+	}
+	return fmt.Errorf("-verify: queries return different results: %s", strings.Join(groups, "; "))
+}
+
+// fetchResult executes query and returns a summary of its result set.
+// Parameters given via a "-- params:" directive are passed by executing the
+// query as a prepared statement, like -m prepare does.
+func fetchResult(ctx context.Context, conn *sql.Conn, query string) (queryResult, error) {
+	stmt := query
+	if _, ok := queryDirective(query, "params"); ok {
+		const name = "sqlbench_verify"
+		prepareSQL, executeSQL := prepareStatements(name, query)
+		if _, err := conn.ExecContext(ctx, prepareSQL); err != nil {
+			return queryResult{}, err
+		}
+		defer conn.ExecContext(ctx, "DEALLOCATE "+name)
+		stmt = executeSQL
+	}
+
+	rows, err := conn.QueryContext(ctx, stmt)
+	if err != nil {
+		return queryResult{}, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return queryResult{}, err
+	}
+	var encoded []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return queryResult{}, err
+		}
+		encoded = append(encoded, encodeRow(values))
+	}
+	if err := rows.Err(); err != nil {
+		return queryResult{}, err
+	}
+	return queryResult{Rows: len(encoded), Hash: resultHash(encoded)}, nil
+}
+
+// encodeRow returns an unambiguous string representation of a row.
+func encodeRow(values []sql.NullString) string {
+	var fields []string
+	for _, v := range values {
+		if !v.Valid {
+			fields = append(fields, "NULL")
+		} else {
+			fields = append(fields, strconv.Quote(v.String))
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// resultHash returns a hash of the encoded rows that doesn't depend on their
+// order.
+func resultHash(encodedRows []string) string {
+	sorted := append([]string{}, encodedRows...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	for _, row := range sorted {
+		fmt.Fprintf(h, "%s\n", row)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:8]
+}
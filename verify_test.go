@@ -0,0 +1,37 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func Test_resultHash(t *testing.T) {
+	a := encodeRow([]sql.NullString{{String: "1", Valid: true}, {}})
+	b := encodeRow([]sql.NullString{{String: "2", Valid: true}, {String: "NULL", Valid: true}})
+	if a == b {
+		t.Fatalf("rows with NULL and 'NULL' encode the same: %q", a)
+	} else if resultHash([]string{a, b}) != resultHash([]string{b, a}) {
+		t.Fatal("hash depends on the order of the rows")
+	} else if resultHash([]string{a}) == resultHash([]string{a, a}) {
+		t.Fatal("hash doesn't depend on duplicate rows")
+	}
+}
+
+func Test_compareResults(t *testing.T) {
+	a, b, c := &Query{Name: "a"}, &Query{Name: "b"}, &Query{Name: "c"}
+	results := map[*Query]queryResult{
+		a: {Rows: 2, Hash: "aaaa"},
+		b: {Rows: 2, Hash: "aaaa"},
+		c: {Rows: 1, Hash: "cccc"},
+	}
+	if err := compareResults([]*Query{a, b}, results); err != nil {
+		t.Fatal(err)
+	}
+	err := compareResults([]*Query{a, b, c}, results)
+	if err == nil {
+		t.Fatal("expected error")
+	} else if want := "a, b: 2 rows (result hash aaaa); c: 1 rows (result hash cccc)"; !strings.HasSuffix(err.Error(), want) {
+		t.Fatalf("got=%q want suffix %q", err, want)
+	}
+}